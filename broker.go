@@ -1,6 +1,9 @@
 package iothub
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // MQTTBroker represents an MQTT server.
 type MQTTBroker struct {
@@ -8,9 +11,19 @@ type MQTTBroker struct {
 	Port int
 }
 
-// URL returns the URL of the MQTT server.
+// URL returns the URL of the MQTT server. If Host is an IPv6 literal it's enclosed in brackets, as URLs require.
 func (b *MQTTBroker) URL() string {
-	return fmt.Sprintf("tls://%s:%d", b.Host, b.Port)
+	return b.url("tls")
+}
+
+// url returns the URL of the MQTT server with the given scheme, e.g. "tcp" for a broker without TLS.
+func (b *MQTTBroker) url(scheme string) string {
+	host := b.Host
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		host = "[" + host + "]"
+	}
+
+	return fmt.Sprintf("%s://%s:%d", scheme, host, b.Port)
 }
 
 // String returns a string representation of the MQTTBroker.
@@ -0,0 +1,28 @@
+package iothub
+
+import (
+	"testing"
+)
+
+func TestURL(t *testing.T) {
+	cases := []struct {
+		host string
+		want string
+	}{
+		{"myhub.azure-devices.net", "tls://myhub.azure-devices.net:8883"},
+		{"192.168.1.10", "tls://192.168.1.10:8883"},
+		{"::1", "tls://[::1]:8883"},
+		{"fe80::1ff:fe23:4567:890a", "tls://[fe80::1ff:fe23:4567:890a]:8883"},
+		{"[::1]", "tls://[::1]:8883"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.host, func(t *testing.T) {
+			b := MQTTBroker{Host: c.host, Port: 8883}
+			got := b.URL()
+			if got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package iothub
+
+import "crypto/tls"
+
+// DeviceBuilder assembles a Device. Build validates the result, so configuration mistakes are caught before
+// attempting to connect. Create one with NewDeviceBuilder.
+type DeviceBuilder struct {
+	d Device
+}
+
+// NewDeviceBuilder returns a DeviceBuilder for the device with the given ID in the named hub.
+func NewDeviceBuilder(hub, deviceID string) *DeviceBuilder {
+	return &DeviceBuilder{
+		d: Device{
+			HubName:  hub,
+			DeviceID: deviceID,
+		},
+	}
+}
+
+// WithCertFiles sets the paths to the device's cert and private key.
+func (b *DeviceBuilder) WithCertFiles(certPath, privKeyPath string) *DeviceBuilder {
+	b.d.CertPath = certPath
+	b.d.PrivKeyPath = privKeyPath
+	return b
+}
+
+// WithCertPEM sets the device's PEM-encoded cert and private key, for when they aren't stored in files.
+func (b *DeviceBuilder) WithCertPEM(certPEM, keyPEM []byte) *DeviceBuilder {
+	b.d.CertPEM = string(certPEM)
+	b.d.KeyPEM = string(keyPEM)
+	return b
+}
+
+// WithClientCert sets the device's cert and private key, already loaded.
+func (b *DeviceBuilder) WithClientCert(cert *tls.Certificate) *DeviceBuilder {
+	b.d.ClientCert = cert
+	return b
+}
+
+// WithSharedAccessKey sets the device's base64-encoded shared access key, for devices that authenticate with a
+// symmetric key rather than a cert.
+func (b *DeviceBuilder) WithSharedAccessKey(key string) *DeviceBuilder {
+	b.d.SharedAccessKey = key
+	return b
+}
+
+// WithModule sets the ID of the module, e.g. an IoT Edge module, as which the device connects.
+func (b *DeviceBuilder) WithModule(moduleID string) *DeviceBuilder {
+	b.d.ModuleID = moduleID
+	return b
+}
+
+// WithGateway sets the host name of the IoT Edge gateway through which the device connects. The gateway's root CA
+// cert must be among the device's CA certs.
+func (b *DeviceBuilder) WithGateway(host string) *DeviceBuilder {
+	b.d.GatewayHostName = host
+	return b
+}
+
+// WithCACerts sets the path to the .pem file containing the root CA certs that the device trusts.
+func (b *DeviceBuilder) WithCACerts(path string) *DeviceBuilder {
+	b.d.CACerts = path
+	return b
+}
+
+// Build returns the assembled Device, or an error if it isn't valid. See Device.Validate.
+func (b *DeviceBuilder) Build() (Device, error) {
+	if err := b.d.Validate(); err != nil {
+		return Device{}, err
+	}
+
+	return b.d, nil
+}
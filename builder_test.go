@@ -0,0 +1,128 @@
+package iothub
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestDeviceBuilder(t *testing.T) {
+	got, err := NewDeviceBuilder("myhub", "foo").
+		WithCertFiles("foo.x509", "foo.pem").
+		WithCACerts("roots.pem").
+		Build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := Device{
+		HubName:     "myhub",
+		DeviceID:    "foo",
+		CACerts:     "roots.pem",
+		CertPath:    "foo.x509",
+		PrivKeyPath: "foo.pem",
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDeviceBuilderSharedAccessKey(t *testing.T) {
+	got, err := NewDeviceBuilder("myhub", "foo").
+		WithSharedAccessKey("c2VjcmV0").
+		WithCACerts("roots.pem").
+		Build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := Device{HubName: "myhub", DeviceID: "foo", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDeviceBuilderModule(t *testing.T) {
+	got, err := NewDeviceBuilder("myhub", "foo").
+		WithModule("sensor").
+		WithSharedAccessKey("c2VjcmV0").
+		WithCACerts("roots.pem").
+		Build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := Device{HubName: "myhub", DeviceID: "foo", ModuleID: "sensor", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDeviceBuilderGateway(t *testing.T) {
+	got, err := NewDeviceBuilder("myhub", "foo").
+		WithGateway("edge.local").
+		WithSharedAccessKey("c2VjcmV0").
+		WithCACerts("roots.pem").
+		Build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := Device{HubName: "myhub", DeviceID: "foo", GatewayHostName: "edge.local", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDeviceBuilderInMemory(t *testing.T) {
+	cert := &tls.Certificate{}
+	cases := []struct {
+		name string
+		b    *DeviceBuilder
+		want Device
+	}{
+		{
+			"PEM",
+			NewDeviceBuilder("myhub", "foo").WithCertPEM([]byte("cert"), []byte("key")).WithCACerts("roots.pem"),
+			Device{HubName: "myhub", DeviceID: "foo", CACerts: "roots.pem", CertPEM: "cert", KeyPEM: "key"},
+		},
+		{
+			"client cert",
+			NewDeviceBuilder("myhub", "foo").WithClientCert(cert).WithCACerts("roots.pem"),
+			Device{HubName: "myhub", DeviceID: "foo", CACerts: "roots.pem", ClientCert: cert},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.b.Build()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDeviceBuilderInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		b    *DeviceBuilder
+	}{
+		{"no hub", NewDeviceBuilder("", "foo").WithCertFiles("foo.x509", "foo.pem").WithCACerts("roots.pem")},
+		{"no device ID", NewDeviceBuilder("myhub", "").WithCertFiles("foo.x509", "foo.pem").WithCACerts("roots.pem")},
+		{"no cert or key", NewDeviceBuilder("myhub", "foo").WithCACerts("roots.pem")},
+		{"no key", NewDeviceBuilder("myhub", "foo").WithCertFiles("foo.x509", "").WithCACerts("roots.pem")},
+		{"no cert", NewDeviceBuilder("myhub", "foo").WithCertFiles("", "foo.pem").WithCACerts("roots.pem")},
+		{"no CA certs", NewDeviceBuilder("myhub", "foo").WithCertFiles("foo.x509", "foo.pem")},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := c.b.Build(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
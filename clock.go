@@ -0,0 +1,63 @@
+package iothub
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// now returns the current time. It's a variable so that tests can control the clock.
+var now = time.Now
+
+// maxClockSkew is how far the local clock may be from IoT Hub's before CheckClockAgainstBroker logs a warning.
+const maxClockSkew = 5 * time.Minute
+
+// CheckClockAgainstBroker compares the local clock with the hub's, as given by the Date header of a response from the
+// hub's HTTPS endpoint, and returns the skew: the hub's time minus the local time, so a positive skew means the local
+// clock is behind. If the skew is more than 5 minutes either way, a warning is logged to paho's WARN logger (see
+// EnablePahoLogging).
+//
+// A wrong clock is a common cause of authentication failures that are hard to diagnose, because credentials such as
+// certs and shared access signatures are only valid for a period of time. The Date header has a resolution of one
+// second, so skews of a second or two are expected.
+func (d *Device) CheckClockAgainstBroker(ctx context.Context) (time.Duration, error) {
+	tlsConf, err := d.NewTLSConfig()
+	if err != nil {
+		return 0, err
+	}
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}
+
+	return checkClock(ctx, httpClient, "https://"+d.BrokerFQDN())
+}
+
+// checkClock is like CheckClockAgainstBroker but sends the request to the given URL.
+func checkClock(ctx context.Context, httpClient *http.Client, url string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("iothub: clock check request failed: %w", err)
+	}
+	resp.Body.Close()
+	end := now()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("iothub: clock check response has no valid Date header: %q", resp.Header.Get("Date"))
+	}
+
+	// Compare against the middle of the request to discount the round trip.
+	local := start.Add(end.Sub(start) / 2)
+	skew := date.Sub(local)
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		mqtt.WARN.Printf("[iothub] local clock differs from IoT Hub's by %v; authentication may fail", skew)
+	}
+	return skew, nil
+}
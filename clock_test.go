@@ -0,0 +1,64 @@
+package iothub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeNow makes now return the time pointed to by t for the duration of the test.
+func fakeNow(tb testing.TB, t *time.Time) {
+	tb.Helper()
+	orig := now
+	tb.Cleanup(func() { now = orig })
+	now = func() time.Time { return *t }
+}
+
+func TestCheckClock(t *testing.T) {
+	local := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fakeNow(t, &local)
+
+	cases := []struct {
+		name string
+		date string
+		want time.Duration
+	}{
+		{"in sync", "Fri, 01 Mar 2024 12:00:00 GMT", 0},
+		{"local behind", "Fri, 01 Mar 2024 12:10:00 GMT", 10 * time.Minute},
+		{"local ahead", "Fri, 01 Mar 2024 11:59:30 GMT", -30 * time.Second},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead {
+					t.Errorf("got method %s, want HEAD", r.Method)
+				}
+				w.Header().Set("Date", c.date)
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			defer srv.Close()
+
+			got, err := checkClock(context.Background(), srv.Client(), srv.URL)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Errorf("got skew %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCheckClockNoDate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Date"] = nil
+	}))
+	defer srv.Close()
+
+	if _, err := checkClock(context.Background(), srv.Client(), srv.URL); err == nil {
+		t.Error("expected error for a response without a Date header")
+	}
+}
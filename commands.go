@@ -0,0 +1,88 @@
+package iothub
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Command is a cloud-to-device message.
+type Command struct {
+	// Topic is the topic on which the command was received.
+	Topic string
+	// Properties are the message's properties, decoded from the property bag at the end of the topic. They include
+	// system properties such as "$.mid" and any application properties the sender set.
+	Properties map[string]string
+	Payload    []byte
+}
+
+// parseCommand parses a cloud-to-device message into a Command.
+func (d *Device) parseCommand(topic string, payload []byte) (Command, error) {
+	prefix := strings.TrimSuffix(d.CommandTopic(), "#")
+	if !strings.HasPrefix(topic, prefix) {
+		return Command{}, fmt.Errorf("iothub: not a command topic: %q", topic)
+	}
+
+	props, err := decodePropertyBag(strings.TrimPrefix(topic, prefix))
+	if err != nil {
+		return Command{}, fmt.Errorf("iothub: malformed property bag in topic %q: %v", topic, err)
+	}
+
+	return Command{Topic: topic, Properties: props, Payload: payload}, nil
+}
+
+// NextCommand subscribes to the device's command topic with QoS 1, waits for a cloud-to-device message, and returns
+// it. It unsubscribes before returning, including when the context is done first, in which case it returns the
+// context's error. It's meant for simple synchronous flows and scripts; to handle a stream of commands, subscribe to
+// CommandTopic directly.
+//
+// Because it replaces and then removes the subscription to the command topic, don't use NextCommand on a client that
+// handles commands in some other way, e.g. with SubscribeAll.
+func (d *Device) NextCommand(ctx context.Context, client mqtt.Client) (Command, error) {
+	topic := d.CommandTopic()
+	ch := make(chan mqtt.Message, 1)
+	handler := func(client mqtt.Client, msg mqtt.Message) {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+
+	if err := waitToken(ctx, client.Subscribe(topic, 1, handler)); err != nil {
+		return Command{}, fmt.Errorf("iothub: failed to subscribe to commands: %w", err)
+	}
+	defer client.Unsubscribe(topic)
+
+	select {
+	case msg := <-ch:
+		return d.parseCommand(msg.Topic(), msg.Payload())
+	case <-ctx.Done():
+		return Command{}, fmt.Errorf("iothub: no command received: %w", ctx.Err())
+	}
+}
+
+// SafeCommandHandler returns a handler for the device's command topic that calls fn with each cloud-to-device
+// message's properties and payload. A panic in fn is recovered and logged to paho's ERROR logger (see
+// EnablePahoLogging) instead of crashing the client's message handling goroutine, and the message is acknowledged
+// either way, so that a QoS 1 message that fn can't handle isn't redelivered forever. Messages that aren't valid
+// commands are logged and acknowledged without calling fn.
+func (d *Device) SafeCommandHandler(fn func(props map[string]string, payload []byte)) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		defer msg.Ack()
+
+		cmd, err := d.parseCommand(msg.Topic(), msg.Payload())
+		if err != nil {
+			mqtt.ERROR.Printf("[iothub] dropping command: %v", err)
+			return
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				mqtt.ERROR.Printf("[iothub] recovered from panic in command handler for topic %q: %v", cmd.Topic, r)
+			}
+		}()
+		fn(cmd.Properties, cmd.Payload)
+	}
+}
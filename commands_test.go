@@ -0,0 +1,100 @@
+package iothub
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestNextCommand(t *testing.T) {
+	topic := "devices/foo/messages/devicebound/%24.mid=1&%24.to=%2Fdevices%2Ffoo%2Fmessages%2Fdevicebound&color=red"
+	client := &fakeClient{
+		onSubscribe: func(c *fakeClient, filter string) {
+			c.deliver(topic, []byte("hello"))
+		},
+	}
+
+	cmd, err := device.NextCommand(context.Background(), client)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := Command{
+		Topic: topic,
+		Properties: map[string]string{
+			"$.mid": "1",
+			"$.to":  "/devices/foo/messages/devicebound",
+			"color": "red",
+		},
+		Payload: []byte("hello"),
+	}
+	if !reflect.DeepEqual(cmd, want) {
+		t.Errorf("got %+v, want %+v", cmd, want)
+	}
+
+	if len(client.unsubscribed) != 1 || client.unsubscribed[0] != device.CommandTopic() {
+		t.Errorf("got unsubscribed %v, want [%v]", client.unsubscribed, device.CommandTopic())
+	}
+}
+
+func TestNextCommandTimeout(t *testing.T) {
+	client := &fakeClient{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := device.NextCommand(ctx, client); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if len(client.unsubscribed) != 1 {
+		t.Errorf("got %d unsubscribed topics, want 1", len(client.unsubscribed))
+	}
+}
+
+func TestSafeCommandHandler(t *testing.T) {
+	var gotProps map[string]string
+	var gotPayload string
+	handler := device.SafeCommandHandler(func(props map[string]string, payload []byte) {
+		gotProps, gotPayload = props, string(payload)
+	})
+
+	msg := &fakeMessage{topic: "devices/foo/messages/devicebound/color=red", qos: 1, payload: []byte("on")}
+	handler(&fakeClient{}, msg)
+
+	if want := map[string]string{"color": "red"}; !reflect.DeepEqual(gotProps, want) {
+		t.Errorf("got properties %v, want %v", gotProps, want)
+	}
+	if gotPayload != "on" {
+		t.Errorf("got payload %q, want %q", gotPayload, "on")
+	}
+	if !msg.acked {
+		t.Error("message wasn't acknowledged")
+	}
+}
+
+func TestSafeCommandHandlerPanic(t *testing.T) {
+	origError := mqtt.ERROR
+	t.Cleanup(func() { mqtt.ERROR = origError })
+	h := &recordingHandler{}
+	mqtt.ERROR = slogLogger{logger: slog.New(h), level: slog.LevelError}
+
+	handler := device.SafeCommandHandler(func(props map[string]string, payload []byte) {
+		panic("boom")
+	})
+
+	msg := &fakeMessage{topic: "devices/foo/messages/devicebound/", qos: 1}
+	handler(&fakeClient{}, msg)
+
+	if !msg.acked {
+		t.Error("message wasn't acknowledged")
+	}
+	if len(h.records) != 1 || !strings.Contains(h.records[0].Message, "boom") {
+		t.Errorf("got log records %v, want one mentioning the panic", h.records)
+	}
+}
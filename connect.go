@@ -0,0 +1,186 @@
+package iothub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// connectError adds a hint to err, an error from connecting, if IoT Hub refused the connection with "Server
+// Unavailable". IoT Hub uses that return code for problems with the username, such as an API version that it
+// doesn't accept, rather than for actual unavailability. paho sometimes flattens the return code's error into a
+// string, so the message is checked as well.
+func connectError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, packets.ErrorRefusedServerUnavailable) ||
+		strings.Contains(err.Error(), packets.ErrorRefusedServerUnavailable.Error()) {
+		return fmt.Errorf("%w (IoT Hub refuses connections with \"Server Unavailable\" when the MQTT username is "+
+			"malformed; check the username, in particular any api-version in it)", err)
+	}
+	return err
+}
+
+// WaitForConnection blocks until the client's connection is open, checking every poll, or until the context is done.
+// It's useful after connecting with auto-reconnect or connect retry enabled, when Connect may return before the
+// connection is established, to hold off on e.g. subscribing until the client is connected.
+func (d *Device) WaitForConnection(ctx context.Context, client mqtt.Client, poll time.Duration) error {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		if client.IsConnectionOpen() {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("iothub: gave up waiting for connection: %w", ctx.Err())
+		}
+	}
+}
+
+// ConnectWithRetry connects the client, retrying failed attempts until one succeeds or the context is done. The
+// delay between attempts starts at initial and doubles after each failure up to max, with random jitter so that
+// many devices that fail at the same time don't retry in lockstep. If the context is done before an attempt succeeds
+// the error from the last attempt is returned.
+//
+// initial must be positive and max must be at least initial.
+//
+// It's meant for riding out transient failures at boot, e.g. DNS or the network not being ready yet.
+func (d *Device) ConnectWithRetry(ctx context.Context, client mqtt.Client, initial, max time.Duration) error {
+	if initial <= 0 {
+		return fmt.Errorf("iothub: initial retry delay must be positive, got %v", initial)
+	}
+	if max < initial {
+		return fmt.Errorf("iothub: maximum retry delay %v is less than the initial delay %v", max, initial)
+	}
+
+	backoff := initial
+	var lastErr error
+	for {
+		err := connectError(waitToken(ctx, client.Connect()))
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() == nil || lastErr == nil {
+			lastErr = err
+		}
+
+		select {
+		case <-time.After(jitter(backoff)):
+		case <-ctx.Done():
+			return fmt.Errorf("iothub: failed to connect: %w", lastErr)
+		}
+
+		backoff *= 2
+		if backoff > max {
+			backoff = max
+		}
+	}
+}
+
+// jitter returns a random duration in [d/2, d).
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// ConnectResult classifies the outcome of connecting, based on the CONNACK return code, so that callers can choose a
+// retry strategy per class of failure, e.g. retrying ConnectServerUnavailable but not ConnectBadCredentials.
+type ConnectResult int
+
+const (
+	// ConnectAccepted means the connection was accepted.
+	ConnectAccepted ConnectResult = iota
+	// ConnectBadProtocolVersion means the broker doesn't support the requested MQTT version.
+	ConnectBadProtocolVersion
+	// ConnectIDRejected means the broker rejected the client ID.
+	ConnectIDRejected
+	// ConnectServerUnavailable means the broker refused the connection with "Server Unavailable". IoT Hub also uses
+	// this for a malformed username.
+	ConnectServerUnavailable
+	// ConnectBadCredentials means the username or password was rejected.
+	ConnectBadCredentials
+	// ConnectNotAuthorized means the client isn't authorized to connect, e.g. because the device is disabled or its
+	// cert doesn't match the device's registration.
+	ConnectNotAuthorized
+	// ConnectNetworkError means the connection failed before a CONNACK was received.
+	ConnectNetworkError
+	// ConnectProtocolViolation means the broker sent something other than a valid CONNACK.
+	ConnectProtocolViolation
+	// ConnectUnknown means the connection failed for a reason not covered by the other results.
+	ConnectUnknown
+)
+
+// connectResultErrors maps the errors paho returns for each CONNACK failure to its ConnectResult.
+var connectResultErrors = []struct {
+	err    error
+	result ConnectResult
+}{
+	{packets.ErrorRefusedBadProtocolVersion, ConnectBadProtocolVersion},
+	{packets.ErrorRefusedIDRejected, ConnectIDRejected},
+	{packets.ErrorRefusedServerUnavailable, ConnectServerUnavailable},
+	{packets.ErrorRefusedBadUsernameOrPassword, ConnectBadCredentials},
+	{packets.ErrorRefusedNotAuthorised, ConnectNotAuthorized},
+	{packets.ErrorNetworkError, ConnectNetworkError},
+	{packets.ErrorProtocolViolation, ConnectProtocolViolation},
+}
+
+func (r ConnectResult) String() string {
+	switch r {
+	case ConnectAccepted:
+		return "accepted"
+	case ConnectBadProtocolVersion:
+		return "bad protocol version"
+	case ConnectIDRejected:
+		return "identifier rejected"
+	case ConnectServerUnavailable:
+		return "server unavailable"
+	case ConnectBadCredentials:
+		return "bad credentials"
+	case ConnectNotAuthorized:
+		return "not authorized"
+	case ConnectNetworkError:
+		return "network error"
+	case ConnectProtocolViolation:
+		return "protocol violation"
+	default:
+		return "unknown"
+	}
+}
+
+// classifyConnectError returns the ConnectResult for err, an error from connecting. As in connectError, the message
+// is checked as well because paho sometimes flattens the return code's error into a string.
+func classifyConnectError(err error) ConnectResult {
+	if err == nil {
+		return ConnectAccepted
+	}
+
+	for _, e := range connectResultErrors {
+		if errors.Is(err, e.err) || strings.Contains(err.Error(), e.err.Error()) {
+			return e.result
+		}
+	}
+	return ConnectUnknown
+}
+
+// ConnectWithResult connects the client, waits for the connection attempt to finish, and returns its classified
+// result along with the error, if any.
+func (d *Device) ConnectWithResult(client mqtt.Client) (ConnectResult, error) {
+	token := client.Connect()
+	token.Wait()
+	err := token.Error()
+	return classifyConnectError(err), connectError(err)
+}
@@ -0,0 +1,177 @@
+package iothub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func TestWaitForConnection(t *testing.T) {
+	client := &fakeClient{}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		client.Connect()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := device.WaitForConnection(ctx, client, time.Millisecond); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWaitForConnectionTimeout(t *testing.T) {
+	client := &fakeClient{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := device.WaitForConnection(ctx, client, time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	errNetwork := errors.New("network is unreachable")
+	client := &fakeClient{
+		connectErrs: []error{errNetwork, errNetwork, errNetwork},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := device.ConnectWithRetry(ctx, client, time.Millisecond, 4*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(client.calls) != 4 {
+		t.Errorf("got %d connect attempts, want 4", len(client.calls))
+	}
+	if !client.IsConnected() {
+		t.Error("client isn't connected")
+	}
+}
+
+func TestConnectWithRetryGivesUp(t *testing.T) {
+	errNetwork := errors.New("network is unreachable")
+	client := &fakeClient{
+		connectErrs: make([]error, 1000),
+	}
+	for i := range client.connectErrs {
+		client.connectErrs[i] = errNetwork
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := device.ConnectWithRetry(ctx, client, time.Millisecond, 2*time.Millisecond)
+	if !errors.Is(err, errNetwork) {
+		t.Errorf("got error %v, want %v", err, errNetwork)
+	}
+}
+
+func TestConnectWithRetryInvalidDelays(t *testing.T) {
+	cases := []struct {
+		name         string
+		initial, max time.Duration
+	}{
+		{"zero initial", 0, time.Second},
+		{"negative initial", -time.Second, time.Second},
+		{"max less than initial", time.Second, time.Millisecond},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := &fakeClient{}
+			if err := device.ConnectWithRetry(context.Background(), client, c.initial, c.max); err == nil {
+				t.Error("got nil error, want error")
+			}
+			if len(client.calls) != 0 {
+				t.Errorf("got calls %v, want none", client.calls)
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	d := 100 * time.Millisecond
+	for i := 0; i < 1000; i++ {
+		if got := jitter(d); got < d/2 || got >= d {
+			t.Fatalf("jitter(%v) = %v, want in [%v, %v)", d, got, d/2, d)
+		}
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	other := errors.New("network is unreachable")
+	cases := []struct {
+		name     string
+		err      error
+		wantHint bool
+	}{
+		{"nil", nil, false},
+		{"server unavailable", packets.ErrorRefusedServerUnavailable, true},
+		{"server unavailable flattened", fmt.Errorf("%s : %s", packets.ErrorRefusedServerUnavailable, io.EOF), true},
+		{"not authorized", packets.ErrorRefusedNotAuthorised, false},
+		{"other", other, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := connectError(c.err)
+			if c.err == nil {
+				if got != nil {
+					t.Errorf("got %v, want nil", got)
+				}
+				return
+			}
+
+			if !errors.Is(got, c.err) {
+				t.Errorf("got error %v that doesn't wrap %v", got, c.err)
+			}
+			if hasHint := strings.Contains(got.Error(), "api-version"); hasHint != c.wantHint {
+				t.Errorf("got error %q, want hint: %v", got, c.wantHint)
+			}
+		})
+	}
+}
+
+func TestConnectWithResult(t *testing.T) {
+	cases := []struct {
+		err  error
+		want ConnectResult
+	}{
+		{nil, ConnectAccepted},
+		{packets.ErrorRefusedBadProtocolVersion, ConnectBadProtocolVersion},
+		{packets.ErrorRefusedIDRejected, ConnectIDRejected},
+		{packets.ErrorRefusedServerUnavailable, ConnectServerUnavailable},
+		{fmt.Errorf("%s : %s", packets.ErrorRefusedServerUnavailable, io.EOF), ConnectServerUnavailable},
+		{packets.ErrorRefusedBadUsernameOrPassword, ConnectBadCredentials},
+		{packets.ErrorRefusedNotAuthorised, ConnectNotAuthorized},
+		{fmt.Errorf("connect failed: %w", packets.ErrorRefusedNotAuthorised), ConnectNotAuthorized},
+		{packets.ErrorNetworkError, ConnectNetworkError},
+		{packets.ErrorProtocolViolation, ConnectProtocolViolation},
+		{errors.New("network is unreachable"), ConnectUnknown},
+	}
+
+	for _, c := range cases {
+		t.Run(c.want.String(), func(t *testing.T) {
+			client := &fakeClient{}
+			if c.err != nil {
+				client.connectErrs = []error{c.err}
+			}
+
+			got, err := device.ConnectWithResult(client)
+			if got != c.want {
+				t.Errorf("got result %v, want %v", got, c.want)
+			}
+			if (err != nil) != (c.err != nil) {
+				t.Errorf("got error %v, want error: %v", err, c.err != nil)
+			}
+		})
+	}
+}
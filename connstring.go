@@ -0,0 +1,123 @@
+package iothub
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ParseConnectionString returns a Device for the device connection string connStr, as shown in the Azure portal, e.g.
+// "HostName=myhub.azure-devices.net;DeviceId=foo;SharedAccessKey=...". A module connection string, which also has a
+// ModuleId, gives a Device with ModuleID set, and one for a device behind an IoT Edge gateway, which also has a
+// GatewayHostName, gives a Device with GatewayHostName set. Connection strings don't include the root CA certs, so caCerts is used
+// as the Device's CACerts.
+//
+// The connection string must have a shared access key; connection strings for X.509 devices (x509=true) and shared
+// access policies (SharedAccessKeyName) aren't supported. The returned error
+// wraps ErrInvalidConnectionString if the connection string is malformed or unsupported. The Device is validated
+// before it's returned.
+func ParseConnectionString(connStr, caCerts string) (Device, error) {
+	d, err := parseConnectionString(connStr)
+	if err != nil {
+		return Device{}, err
+	}
+
+	d.CACerts = caCerts
+	if err := d.Validate(); err != nil {
+		return Device{}, err
+	}
+	return d, nil
+}
+
+// parseConnectionString is like ParseConnectionString but doesn't set the Device's CACerts or validate it.
+func parseConnectionString(connStr string) (Device, error) {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(connStr, ";") {
+		if part == "" {
+			continue
+		}
+
+		// Split on the first "=" only, as base64 keys may end with padding.
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || key == "" {
+			return Device{}, fmt.Errorf("%w: malformed field %q", ErrInvalidConnectionString, part)
+		}
+		if _, ok := fields[key]; ok {
+			return Device{}, fmt.Errorf("%w: duplicate field %s", ErrInvalidConnectionString, key)
+		}
+		fields[key] = value
+	}
+
+	if _, ok := fields["SharedAccessKeyName"]; ok {
+		return Device{}, fmt.Errorf("%w: SharedAccessKeyName is not supported", ErrInvalidConnectionString)
+	}
+	if strings.EqualFold(fields["x509"], "true") {
+		return Device{}, fmt.Errorf("%w: X.509 connection strings are not supported; set the device's cert and key paths instead", ErrInvalidConnectionString)
+	}
+	for _, key := range []string{"HostName", "DeviceId", "SharedAccessKey"} {
+		if fields[key] == "" {
+			return Device{}, fmt.Errorf("%w: %s is required", ErrInvalidConnectionString, key)
+		}
+	}
+
+	hub, ok := hubNameFromHost(fields["HostName"])
+	if !ok {
+		return Device{}, fmt.Errorf("%w: HostName must be of the form {hub name}.%s, got %q", ErrInvalidConnectionString, azureDevicesEndpoint, fields["HostName"])
+	}
+
+	return Device{
+		HubName:         hub,
+		DeviceID:        fields["DeviceId"],
+		ModuleID:        fields["ModuleId"],
+		GatewayHostName: fields["GatewayHostName"],
+		SharedAccessKey: fields["SharedAccessKey"],
+	}, nil
+}
+
+// ConnectionString returns the device's connection string, the inverse of ParseConnectionString, e.g. for use with the
+// Azure CLI. It includes ModuleId if ModuleID is set and GatewayHostName if GatewayHostName is set. The connection string embeds the device's shared access key, so
+// it's an error if the device doesn't have one, and it's as sensitive as the key itself.
+func (d *Device) ConnectionString() (string, error) {
+	if d.SharedAccessKey == "" {
+		return "", fmt.Errorf("iothub: device has no shared access key to put in a connection string")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "HostName=%s;DeviceId=%s", d.hubHost(), d.DeviceID)
+	if d.ModuleID != "" {
+		fmt.Fprintf(&b, ";ModuleId=%s", d.ModuleID)
+	}
+	fmt.Fprintf(&b, ";SharedAccessKey=%s", d.SharedAccessKey)
+	if d.GatewayHostName != "" {
+		fmt.Fprintf(&b, ";GatewayHostName=%s", d.GatewayHostName)
+	}
+	return b.String(), nil
+}
+
+// NewClientFromConnectionString returns a client for the device or module that connStr describes, parsed as by
+// ParseConnectionString, with the given options applied. The root CA certs are read from caCerts as PEM, so they may
+// be e.g. embedded in the binary. The client authenticates with shared access signatures generated from the
+// connection string's key, and renews them as described by NewClient.
+//
+// Use ParseConnectionString and NewClient directly if you need the Device, e.g. for its topics or helpers.
+func NewClientFromConnectionString(connStr string, caCerts io.Reader, options ...func(*Device, *mqtt.ClientOptions) error) (mqtt.Client, error) {
+	if caCerts == nil {
+		return nil, fmt.Errorf("iothub: CA certs are required")
+	}
+
+	d, err := parseConnectionString(connStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.validateCredentials(); err != nil {
+		return nil, err
+	}
+
+	opts, err := d.BuildOptions(caCerts, options...)
+	if err != nil {
+		return nil, err
+	}
+	return newMQTTClient(opts), nil
+}
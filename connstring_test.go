@@ -0,0 +1,187 @@
+package iothub
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConnectionString(t *testing.T) {
+	cases := []struct {
+		name    string
+		connStr string
+		want    Device
+	}{
+		{
+			"device",
+			"HostName=myhub.azure-devices.net;DeviceId=foo;SharedAccessKey=c2VjcmV0MQ==",
+			Device{HubName: "myhub", DeviceID: "foo", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0MQ=="},
+		},
+		{
+			"module",
+			"HostName=myhub.azure-devices.net;DeviceId=foo;ModuleId=sensor;SharedAccessKey=c2VjcmV0",
+			Device{HubName: "myhub", DeviceID: "foo", ModuleID: "sensor", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0"},
+		},
+		{
+			"gateway",
+			"HostName=myhub.azure-devices.net;DeviceId=foo;SharedAccessKey=c2VjcmV0;GatewayHostName=edge.local",
+			Device{HubName: "myhub", DeviceID: "foo", GatewayHostName: "edge.local", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0"},
+		},
+		{
+			"trailing semicolon",
+			"HostName=myhub.azure-devices.net;DeviceId=foo;SharedAccessKey=c2VjcmV0;",
+			Device{HubName: "myhub", DeviceID: "foo", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ParseConnectionString(c.connStr, "roots.pem")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Equal(c.want) {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseConnectionStringErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		connStr string
+		wantErr error
+	}{
+		{"empty", "", ErrInvalidConnectionString},
+		{"no key", "HostName=myhub.azure-devices.net;DeviceId=foo", ErrInvalidConnectionString},
+		{"no device ID", "HostName=myhub.azure-devices.net;SharedAccessKey=c2VjcmV0", ErrInvalidConnectionString},
+		{"malformed field", "HostName=myhub.azure-devices.net;DeviceId;SharedAccessKey=c2VjcmV0", ErrInvalidConnectionString},
+		{"duplicate field", "HostName=myhub.azure-devices.net;DeviceId=foo;DeviceId=bar;SharedAccessKey=c2VjcmV0", ErrInvalidConnectionString},
+		{"other host", "HostName=myhub.example.com;DeviceId=foo;SharedAccessKey=c2VjcmV0", ErrInvalidConnectionString},
+		{"x509", "HostName=myhub.azure-devices.net;DeviceId=foo;x509=true", ErrInvalidConnectionString},
+		{"policy", "HostName=myhub.azure-devices.net;SharedAccessKeyName=iothubowner;SharedAccessKey=c2VjcmV0", ErrInvalidConnectionString},
+		{"bad key", "HostName=myhub.azure-devices.net;DeviceId=foo;SharedAccessKey=not base64!", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParseConnectionString(c.connStr, "roots.pem")
+			if err == nil {
+				t.Fatal("got nil error, want error")
+			}
+			if c.wantErr != nil && !errors.Is(err, c.wantErr) {
+				t.Errorf("got error %v, want %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewClientFromConnectionString(t *testing.T) {
+	clock := time.Unix(1700000000, 0).Add(-DefaultSASTokenTTL)
+	fakeNow(t, &clock)
+	created := fakeNewMQTTClient(t)
+
+	caPEM, _ := newTestCert(t, "Test Root CA")
+	wantPool := x509.NewCertPool()
+	wantPool.AppendCertsFromPEM(caPEM)
+
+	connStr := "HostName=myhub.azure-devices.net;DeviceId=foo;SharedAccessKey=c2VjcmV0"
+	if _, err := NewClientFromConnectionString(connStr, bytes.NewReader(caPEM)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	opts, ok := created["foo"]
+	if !ok {
+		t.Fatalf("no client created with client ID foo; got %v", created)
+	}
+	if got, want := opts.Servers[0].String(), "tls://myhub.azure-devices.net:8883"; got != want {
+		t.Errorf("got broker %q, want %q", got, want)
+	}
+	if opts.TLSConfig.RootCAs == nil || !opts.TLSConfig.RootCAs.Equal(wantPool) {
+		t.Error("client doesn't trust the given root CA certs")
+	}
+	if opts.CredentialsProvider == nil {
+		t.Fatal("no credentials provider was set")
+	}
+	username, password := opts.CredentialsProvider()
+	if want := "myhub.azure-devices.net/foo"; username != want {
+		t.Errorf("got username %q, want %q", username, want)
+	}
+	want := "SharedAccessSignature sr=myhub.azure-devices.net%2Fdevices%2Ffoo&sig=pV6uK5vKl7p9tPkFuwt1vn%2BL1WO5qBuUx9OvrSfw3VI%3D&se=1700000000"
+	if password != want {
+		t.Errorf("got password %q, want %q", password, want)
+	}
+}
+
+func TestNewClientFromConnectionStringInvalid(t *testing.T) {
+	fakeNewMQTTClient(t)
+
+	caPEM, _ := newTestCert(t, "Test Root CA")
+	_, err := NewClientFromConnectionString("HostName=myhub.azure-devices.net;DeviceId=foo", bytes.NewReader(caPEM))
+	if !errors.Is(err, ErrInvalidConnectionString) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidConnectionString)
+	}
+
+	connStr := "HostName=myhub.azure-devices.net;DeviceId=foo;SharedAccessKey=c2VjcmV0"
+	if _, err := NewClientFromConnectionString(connStr, nil); err == nil {
+		t.Error("got nil error without CA certs, want error")
+	}
+	if _, err := NewClientFromConnectionString(connStr, strings.NewReader("not PEM")); err == nil {
+		t.Error("got nil error for CA certs that aren't PEM, want error")
+	}
+}
+
+func TestConnectionString(t *testing.T) {
+	cases := []struct {
+		name string
+		d    Device
+		want string
+	}{
+		{
+			"device",
+			Device{HubName: "myhub", DeviceID: "foo", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0MQ=="},
+			"HostName=myhub.azure-devices.net;DeviceId=foo;SharedAccessKey=c2VjcmV0MQ==",
+		},
+		{
+			"module",
+			Device{HubName: "myhub", DeviceID: "foo", ModuleID: "sensor", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0"},
+			"HostName=myhub.azure-devices.net;DeviceId=foo;ModuleId=sensor;SharedAccessKey=c2VjcmV0",
+		},
+		{
+			"gateway",
+			Device{HubName: "myhub", DeviceID: "foo", GatewayHostName: "edge.local", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0"},
+			"HostName=myhub.azure-devices.net;DeviceId=foo;SharedAccessKey=c2VjcmV0;GatewayHostName=edge.local",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.d.ConnectionString()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+
+			parsed, err := ParseConnectionString(got, c.d.CACerts)
+			if err != nil {
+				t.Fatalf("ParseConnectionString: unexpected error: %v", err)
+			}
+			if !parsed.Equal(c.d) {
+				t.Errorf("round trip: got %+v, want %+v", parsed, c.d)
+			}
+		})
+	}
+}
+
+func TestConnectionStringCertDevice(t *testing.T) {
+	d := newTestDevice(t)
+	if _, err := d.ConnectionString(); err == nil {
+		t.Error("got nil error for a device without a shared access key, want error")
+	}
+}
@@ -0,0 +1,97 @@
+package iothub
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// credentials holds a device's client cert. It's safe for concurrent use.
+type credentials struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// get returns the current cert. It has the signature of tls.Config.GetClientCertificate.
+func (c *credentials) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+func (c *credentials) set(cert *tls.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = cert
+}
+
+// ReloadCredentials loads the cert and private key at the given paths, e.g. after the device's cert is renewed. If
+// they're a valid pair and the cert is currently valid, the device's CertPath and PrivKeyPath are updated, replacing
+// any other source of the cert (CombinedPEMPath, CertPEM and KeyPEM, or ClientCert), and clients created by NewClient
+// use the new cert the next time they connect. Otherwise the device is left unchanged. The key is decrypted with
+// d.KeyPassphrase if it's set.
+//
+// Devices that authenticate with a shared access key have no cert to reload, so ReloadCredentials returns an error
+// for them.
+//
+// IoT Hub only checks the client cert during the TLS handshake, so an open connection keeps using the old cert until
+// it reconnects. Use ReloadCredentialsAndReconnect to reconnect right away.
+func (d *Device) ReloadCredentials(certPath, keyPath string) error {
+	if d.SharedAccessKey != "" {
+		return fmt.Errorf("iothub: can't reload credentials of a device that authenticates with a shared access key")
+	}
+
+	cert, err := d.loadKeyPairFiles(certPath, keyPath)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrKeyPairLoad, err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrKeyPairLoad, err)
+	}
+	if t := now(); t.Before(leaf.NotBefore) || t.After(leaf.NotAfter) {
+		return fmt.Errorf("iothub: cert is valid from %v to %v, not now", leaf.NotBefore, leaf.NotAfter)
+	}
+
+	d.CertPath = certPath
+	d.PrivKeyPath = keyPath
+	d.CombinedPEMPath = ""
+	d.CertPEM = ""
+	d.KeyPEM = ""
+	d.ClientCert = nil
+	if d.creds == nil {
+		d.creds = &credentials{}
+	}
+	d.creds.set(&cert)
+
+	return nil
+}
+
+// ReloadCredentialsAndReconnect reloads the device's credentials as described by ReloadCredentials and then
+// disconnects the client, waiting up to quiesce for in-flight work to complete, and connects it again so that the
+// new cert takes effect. The client must have been created with NewClient after the device's cert was first loaded.
+//
+// The subscriptions made by this package's helpers (ServeMethods, OnDesiredPropertiesChange, SubscribeAll, Events,
+// and GetTwin) are restored once the client has reconnected. Other subscriptions don't survive the reconnect unless
+// the session is persistent; resubscribe in an OnConnect handler.
+func (d *Device) ReloadCredentialsAndReconnect(ctx context.Context, client mqtt.Client, certPath, keyPath string, quiesce time.Duration) error {
+	if err := d.ReloadCredentials(certPath, keyPath); err != nil {
+		return err
+	}
+
+	client.Disconnect(uint(quiesce.Milliseconds()))
+	if err := connectError(waitToken(ctx, client.Connect())); err != nil {
+		return fmt.Errorf("iothub: failed to reconnect: %w", err)
+	}
+	if err := resubscribe(ctx, client); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package iothub
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReloadCredentials(t *testing.T) {
+	d := newTestDevice(t)
+	conf, err := d.NewTLSConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	certPEM, keyPEM := newTestCert(t, "foo")
+	certPath := writeTestFile(t, "foo-renewed.x509", certPEM)
+	keyPath := writeTestFile(t, "foo-renewed.pem", keyPEM)
+	if err := d.ReloadCredentials(certPath, keyPath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if d.CertPath != certPath || d.PrivKeyPath != keyPath {
+		t.Errorf("got paths (%q, %q), want (%q, %q)", d.CertPath, d.PrivKeyPath, certPath, keyPath)
+	}
+
+	// The TLS config built before the reload supplies the new cert.
+	cert, err := conf.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, err := readCert(bytes.NewReader(certPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.Certificate[0], want.Raw) {
+		t.Error("TLS config doesn't supply the reloaded cert")
+	}
+}
+
+func TestReloadCredentialsReplacesOtherSources(t *testing.T) {
+	certPEM, keyPEM := newTestCert(t, "foo")
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		setup func(d *Device)
+	}{
+		{"cert PEM", func(d *Device) { d.CertPEM, d.KeyPEM = string(certPEM), string(keyPEM) }},
+		{"combined PEM path", func(d *Device) {
+			d.CombinedPEMPath = writeTestFile(t, "foo-combined.pem", append(certPEM, keyPEM...))
+		}},
+		{"client cert", func(d *Device) { d.ClientCert = &clientCert }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := newTestDevice(t)
+			d.CertPath, d.PrivKeyPath = "", ""
+			c.setup(&d)
+
+			renewedCertPEM, renewedKeyPEM := newTestCert(t, "foo")
+			certPath := writeTestFile(t, "foo-renewed.x509", renewedCertPEM)
+			keyPath := writeTestFile(t, "foo-renewed.pem", renewedKeyPEM)
+			if err := d.ReloadCredentials(certPath, keyPath); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := d.Validate(); err != nil {
+				t.Errorf("device not valid after reload: %v", err)
+			}
+
+			// A TLS config built after the reload supplies the new cert too.
+			conf, err := d.NewTLSConfig()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			cert, err := conf.GetClientCertificate(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want, err := readCert(bytes.NewReader(renewedCertPEM))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cert.Certificate[0], want.Raw) {
+				t.Error("TLS config doesn't supply the reloaded cert")
+			}
+		})
+	}
+}
+
+func TestReloadCredentialsSharedAccessKey(t *testing.T) {
+	d := Device{HubName: "myhub", DeviceID: "foo", SharedAccessKey: "c2VjcmV0"}
+
+	certPEM, keyPEM := newTestCert(t, "foo")
+	err := d.ReloadCredentials(writeTestFile(t, "foo.x509", certPEM), writeTestFile(t, "foo.pem", keyPEM))
+	if err == nil {
+		t.Fatal("got nil error, want error")
+	}
+	if d.CertPath != "" || d.PrivKeyPath != "" {
+		t.Errorf("got paths (%q, %q), want them unset", d.CertPath, d.PrivKeyPath)
+	}
+}
+
+func TestReloadCredentialsExpired(t *testing.T) {
+	d := newTestDevice(t)
+	later := time.Now().Add(2 * time.Hour)
+	fakeNow(t, &later)
+
+	certPEM, keyPEM := newTestCert(t, "foo")
+	if err := d.ReloadCredentials(writeTestFile(t, "foo.x509", certPEM), writeTestFile(t, "foo.pem", keyPEM)); err == nil {
+		t.Error("got nil error for a cert that has expired, want error")
+	}
+}
+
+func TestReloadCredentialsInvalid(t *testing.T) {
+	d := newTestDevice(t)
+	before := d
+
+	certPEM, _ := newTestCert(t, "foo")
+	_, otherKeyPEM := newTestCert(t, "bar")
+	err := d.ReloadCredentials(writeTestFile(t, "foo-renewed.x509", certPEM), writeTestFile(t, "bar.pem", otherKeyPEM))
+	if err == nil {
+		t.Fatal("expected error for mismatched cert and key")
+	}
+
+	if !reflect.DeepEqual(d, before) {
+		t.Errorf("device changed after failed reload: got %+v, want %+v", d, before)
+	}
+}
+
+func TestReloadCredentialsAndReconnect(t *testing.T) {
+	d := newTestDevice(t)
+	client := &fakeClient{connected: true}
+
+	certPEM, keyPEM := newTestCert(t, "foo")
+	err := d.ReloadCredentialsAndReconnect(context.Background(), client,
+		writeTestFile(t, "foo-renewed.x509", certPEM), writeTestFile(t, "foo-renewed.pem", keyPEM), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"Disconnect", "Connect"}; !reflect.DeepEqual(client.calls, want) {
+		t.Errorf("got calls %v, want %v", client.calls, want)
+	}
+}
+
+func TestReloadCredentialsAndReconnectResubscribes(t *testing.T) {
+	d := newTestDevice(t)
+	client := &fakeClient{connected: true, cleanSession: true}
+
+	var r MethodRouter
+	r.Handle("reboot", func(payload []byte) (int, []byte) { return 200, nil })
+	if err := d.ServeMethods(client, &r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	certPEM, keyPEM := newTestCert(t, "foo")
+	err := d.ReloadCredentialsAndReconnect(context.Background(), client,
+		writeTestFile(t, "foo-renewed.x509", certPEM), writeTestFile(t, "foo-renewed.pem", keyPEM), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := client.subscriptions[d.MethodRequestTopic()]; !ok {
+		t.Fatal("method request subscription wasn't restored after the reconnect")
+	}
+	client.deliver("$iothub/methods/POST/reboot/?$rid=1", nil)
+	if len(client.published) != 1 || client.published[0].topic != "$iothub/methods/res/200/?$rid=1" {
+		t.Errorf("got published messages %v, want a response to the method request", client.published)
+	}
+}
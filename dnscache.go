@@ -0,0 +1,182 @@
+package iothub
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"golang.org/x/net/proxy"
+)
+
+// Resolver looks up host names. *net.Resolver implements it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// DNSCache caches host name lookups for a fixed time. Use it with WithDNSCache to avoid looking up the broker's host
+// name on every reconnect, which adds latency and load during reconnect storms on flaky networks.
+//
+// A DNSCache is safe for concurrent use and may be shared by many clients.
+type DNSCache struct {
+	resolver Resolver
+	ttl      time.Duration
+	dialer   proxy.Dialer
+
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+// NewDNSCache returns a DNSCache that looks up host names with resolver and caches the results for ttl. If resolver
+// is nil, net.DefaultResolver is used. Failed lookups aren't cached.
+func NewDNSCache(resolver Resolver, ttl time.Duration) *DNSCache {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	return &DNSCache{
+		resolver: resolver,
+		ttl:      ttl,
+		dialer:   &net.Dialer{},
+		entries:  make(map[string]dnsCacheEntry),
+	}
+}
+
+// SetDialer makes DialContext connect to the looked-up addresses with dialer instead of a net.Dialer, e.g. a SOCKS5
+// dialer from proxy.SOCKS5 for devices behind a proxy. It's how to use a DNS cache and a proxy together, since
+// WithDNSCache and WithDialer can't be combined. Call it before the cache is used.
+func (c *DNSCache) SetDialer(dialer proxy.Dialer) {
+	c.dialer = dialer
+}
+
+// LookupHost returns the addresses of host, from the cache if they were looked up less than the TTL ago.
+func (c *DNSCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && now().Before(e.expires) {
+		return e.addrs, nil
+	}
+
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[host] = dnsCacheEntry{addrs: addrs, expires: now().Add(c.ttl)}
+	c.mu.Unlock()
+
+	return addrs, nil
+}
+
+// DialContext connects to addr, a host and port, on the named network. The host is looked up with LookupHost and
+// each of its addresses is tried in turn until one connects. If none of them do, the host's cached addresses are
+// dropped so that the next dial looks it up again, e.g. to find the new address of a hub that has failed over.
+func (c *DNSCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return dialContext(ctx, c.dialer, network, addr)
+	}
+
+	ips, err := c.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := dialContext(ctx, c.dialer, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses for host %s", host)
+	}
+
+	c.mu.Lock()
+	delete(c.entries, host)
+	c.mu.Unlock()
+
+	return nil, lastErr
+}
+
+// WithDNSCache returns an option that makes the client look up the broker's host name with cache when it connects.
+// Like WithDialer, it sets the client's custom open connection function, so it's an error to combine it with
+// WithDialer or any other option that does; to connect through a proxy, see DNSCache.SetDialer.
+func WithDNSCache(cache *DNSCache) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		return setOpenConnection(opts, "WithDNSCache", openConnection(cache.DialContext))
+	}
+}
+
+// setOpenConnection sets the client's custom open connection function to fn, which is set by the named option. It
+// returns an error if one is already set, rather than silently replacing it.
+func setOpenConnection(opts *mqtt.ClientOptions, option string, fn mqtt.OpenConnectionFunc) error {
+	if opts.CustomOpenConnectionFn != nil {
+		return fmt.Errorf("iothub: %s can't be used with another option that sets how the connection is opened, e.g. WithDialer or WithDNSCache", option)
+	}
+	opts.SetCustomOpenConnectionFn(fn)
+	return nil
+}
+
+// dialContext dials addr with dialer, bounded by the context if dialer implements proxy.ContextDialer.
+func dialContext(ctx context.Context, dialer proxy.Dialer, network, addr string) (net.Conn, error) {
+	if cd, ok := dialer.(proxy.ContextDialer); ok {
+		return cd.DialContext(ctx, network, addr)
+	}
+	return dialer.Dial(network, addr)
+}
+
+// openConnection returns a paho OpenConnectionFunc that opens a connection to the broker with dial. Unless the broker
+// URL's scheme is plain MQTT (tcp:// or mqtt://), it then makes the TLS handshake over the connection using the
+// client's TLS config, bounded by the client's connect timeout.
+func openConnection(dial func(ctx context.Context, network, addr string) (net.Conn, error)) mqtt.OpenConnectionFunc {
+	return func(uri *url.URL, opts mqtt.ClientOptions) (net.Conn, error) {
+		ctx := context.Background()
+		if opts.ConnectTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.ConnectTimeout)
+			defer cancel()
+		}
+
+		conn, err := dial(ctx, "tcp", uri.Host)
+		if err != nil {
+			return nil, err
+		}
+		if uri.Scheme == "tcp" || uri.Scheme == "mqtt" {
+			return conn, nil
+		}
+
+		conf := opts.TLSConfig
+		if conf == nil {
+			conf = &tls.Config{}
+		}
+		if conf.ServerName == "" {
+			// As tls.Dial does, verify the broker's cert against the host that was dialed.
+			conf = conf.Clone()
+			conf.ServerName = uri.Hostname()
+		}
+
+		tlsConn := tls.Client(conn, conf)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,202 @@
+package iothub
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeResolver resolves every host to its addrs and counts lookups.
+type fakeResolver struct {
+	mu      sync.Mutex
+	addrs   []string
+	lookups int
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	return r.addrs, nil
+}
+
+func TestDNSCacheLookupHost(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fakeNow(t, &clock)
+
+	r := &fakeResolver{addrs: []string{"192.0.2.1"}}
+	c := NewDNSCache(r, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		addrs, err := c.LookupHost(context.Background(), "myhub.azure-devices.net")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+			t.Errorf("got %v, want [192.0.2.1]", addrs)
+		}
+	}
+	if r.lookups != 1 {
+		t.Errorf("got %d lookups within the TTL, want 1", r.lookups)
+	}
+
+	clock = clock.Add(time.Minute)
+	if _, err := c.LookupHost(context.Background(), "myhub.azure-devices.net"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.lookups != 2 {
+		t.Errorf("got %d lookups after the TTL, want 2", r.lookups)
+	}
+}
+
+func TestDNSCacheLookupHostCanceled(t *testing.T) {
+	c := NewDNSCache(&fakeResolver{addrs: []string{"192.0.2.1"}}, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.LookupHost(ctx, "myhub.azure-devices.net"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDNSCacheDialContextEvictsOnFailure(t *testing.T) {
+	// Find a port that nothing is listening on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	r := &fakeResolver{addrs: []string{"127.0.0.1"}}
+	c := NewDNSCache(r, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if conn, err := c.DialContext(context.Background(), "tcp", net.JoinHostPort("myhub.azure-devices.net", port)); err == nil {
+			conn.Close()
+			t.Fatal("got nil error dialing a closed port, want error")
+		}
+	}
+	if r.lookups != 2 {
+		t.Errorf("got %d lookups, want 2: the addresses that failed to dial should have been dropped", r.lookups)
+	}
+}
+
+// newTLSListener starts a TLS server on localhost whose self-signed cert is valid for host. It completes a handshake
+// with each connection and then closes it. It returns the listener's port and a pool that trusts its cert.
+func newTLSListener(t *testing.T, host string) (string, *x509.CertPool) {
+	t.Helper()
+
+	port, certPEM := newTLSListenerPEM(t, host)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return port, pool
+}
+
+// newTLSListenerPEM is like newTLSListener but returns the server's PEM-encoded cert rather than a pool.
+func newTLSListenerPEM(t *testing.T, host string) (string, []byte) {
+	t.Helper()
+
+	certPEM, keyPEM := newTestCert(t, host, host)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port, certPEM
+}
+
+func TestWithDNSCache(t *testing.T) {
+	port, pool := newTLSListener(t, "myhub.azure-devices.net")
+
+	r := &fakeResolver{addrs: []string{"127.0.0.1"}}
+	opts := mqtt.NewClientOptions()
+	opts.SetTLSConfig(&tls.Config{RootCAs: pool})
+	opts.SetConnectTimeout(5 * time.Second)
+	if err := WithDNSCache(NewDNSCache(r, time.Minute))(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.CustomOpenConnectionFn == nil {
+		t.Fatal("custom open connection function isn't set")
+	}
+
+	uri := &url.URL{Scheme: "tls", Host: net.JoinHostPort("myhub.azure-devices.net", port)}
+	for i := 0; i < 2; i++ {
+		conn, err := opts.CustomOpenConnectionFn(uri, *opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !conn.(*tls.Conn).ConnectionState().HandshakeComplete {
+			t.Error("TLS handshake isn't complete")
+		}
+		conn.Close()
+	}
+
+	if r.lookups != 1 {
+		t.Errorf("got %d lookups, want 1", r.lookups)
+	}
+}
+
+func TestWithDNSCachePlainTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	opts := mqtt.NewClientOptions()
+	if err := WithDNSCache(NewDNSCache(&fakeResolver{addrs: []string{"127.0.0.1"}}, time.Minute))(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, scheme := range []string{"tcp", "mqtt"} {
+		uri := &url.URL{Scheme: scheme, Host: net.JoinHostPort("localhost", port)}
+		conn, err := opts.CustomOpenConnectionFn(uri, *opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", scheme, err)
+		}
+		if _, ok := conn.(*tls.Conn); ok {
+			t.Errorf("%s: got a TLS connection to a plain MQTT broker", scheme)
+		}
+		conn.Close()
+	}
+}
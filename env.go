@@ -0,0 +1,99 @@
+package iothub
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Environment variables read by DeviceFromEnv.
+const (
+	EnvHost          = "IOTHUB_HOST"
+	EnvName          = "IOTHUB_NAME"
+	EnvDeviceID      = "IOTHUB_DEVICE_ID"
+	EnvModuleID      = "IOTHUB_MODULE_ID"
+	EnvGatewayHost   = "IOTHUB_GATEWAY_HOST"
+	EnvCACerts       = "IOTHUB_CA_CERTS"
+	EnvCertPath      = "IOTHUB_CERT_PATH"
+	EnvKeyPath       = "IOTHUB_KEY_PATH"
+	EnvKeyPassphrase = "IOTHUB_KEY_PASSPHRASE"
+	EnvSASKey        = "IOTHUB_SAS_KEY"
+)
+
+// DeviceFromEnv returns a Device configured from environment variables, for twelve-factor-style deployments:
+//
+//   - IOTHUB_HOST: the hub's host name, e.g. myhub.azure-devices.net. Alternatively set IOTHUB_NAME.
+//   - IOTHUB_NAME: the hub's name, e.g. myhub. Alternatively set IOTHUB_HOST.
+//   - IOTHUB_DEVICE_ID: the device ID.
+//   - IOTHUB_MODULE_ID (optional): the module ID, to connect as a module in the device.
+//   - IOTHUB_GATEWAY_HOST (optional): the host name of an IoT Edge gateway through which to connect.
+//   - IOTHUB_CA_CERTS: the path to the root CA certs file.
+//   - IOTHUB_CERT_PATH: the path to the device's cert.
+//   - IOTHUB_KEY_PATH: the path to the device's private key.
+//   - IOTHUB_KEY_PASSPHRASE (optional): the passphrase for the private key, if it's encrypted.
+//   - IOTHUB_SAS_KEY: the device's shared access key, for devices that authenticate with a symmetric key rather than
+//     a cert. If it's set, IOTHUB_CERT_PATH and IOTHUB_KEY_PATH aren't required.
+//
+// It returns an error naming the variable if a required variable is missing, and an error if the resulting Device
+// isn't valid (see Device.Validate).
+func DeviceFromEnv() (Device, error) {
+	var d Device
+
+	host, name := os.Getenv(EnvHost), os.Getenv(EnvName)
+	switch {
+	case host != "" && name != "":
+		return Device{}, fmt.Errorf("iothub: only one of %s and %s may be set", EnvHost, EnvName)
+	case host != "":
+		hub, ok := hubNameFromHost(host)
+		if !ok {
+			return Device{}, fmt.Errorf("iothub: %s must be of the form {hub name}.%s, got %q", EnvHost, azureDevicesEndpoint, host)
+		}
+		d.HubName = hub
+	case name != "":
+		d.HubName = name
+	default:
+		return Device{}, fmt.Errorf("iothub: environment variable %s or %s is required", EnvHost, EnvName)
+	}
+
+	required := []struct {
+		name  string
+		field *string
+	}{
+		{EnvDeviceID, &d.DeviceID},
+		{EnvCACerts, &d.CACerts},
+		{EnvCertPath, &d.CertPath},
+		{EnvKeyPath, &d.PrivKeyPath},
+	}
+	d.SharedAccessKey = os.Getenv(EnvSASKey)
+	if d.SharedAccessKey != "" {
+		// A device with a shared access key doesn't need a cert. If one is given anyway, Validate reports the conflict.
+		required = required[:2]
+		d.CertPath = os.Getenv(EnvCertPath)
+		d.PrivKeyPath = os.Getenv(EnvKeyPath)
+	}
+	for _, r := range required {
+		*r.field = os.Getenv(r.name)
+		if *r.field == "" {
+			return Device{}, fmt.Errorf("iothub: environment variable %s is required", r.name)
+		}
+	}
+
+	d.ModuleID = os.Getenv(EnvModuleID)
+	d.GatewayHostName = os.Getenv(EnvGatewayHost)
+	d.KeyPassphrase = os.Getenv(EnvKeyPassphrase)
+
+	if err := d.Validate(); err != nil {
+		return Device{}, err
+	}
+	return d, nil
+}
+
+// hubNameFromHost returns the hub name from a host name of the form {hub name}.azure-devices.net. It reports whether
+// host has that form.
+func hubNameFromHost(host string) (string, bool) {
+	hub, ok := strings.CutSuffix(host, "."+azureDevicesEndpoint)
+	if !ok || hub == "" || strings.Contains(hub, ".") {
+		return "", false
+	}
+	return hub, true
+}
@@ -0,0 +1,164 @@
+package iothub
+
+import (
+	"strings"
+	"testing"
+)
+
+func setTestEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	// Clear everything DeviceFromEnv reads so that the environment the tests run in doesn't leak in.
+	for _, name := range []string{EnvHost, EnvName, EnvDeviceID, EnvModuleID, EnvGatewayHost, EnvCACerts, EnvCertPath, EnvKeyPath, EnvKeyPassphrase, EnvSASKey} {
+		t.Setenv(name, "")
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+func TestDeviceFromEnv(t *testing.T) {
+	want := Device{
+		HubName:       "myhub",
+		DeviceID:      "foo",
+		CACerts:       "roots.pem",
+		CertPath:      "foo.x509",
+		PrivKeyPath:   "foo.pem",
+		KeyPassphrase: "hunter2",
+	}
+
+	cases := []struct {
+		name    string
+		hubVar  string
+		hubName string
+	}{
+		{"host", EnvHost, "myhub.azure-devices.net"},
+		{"name", EnvName, "myhub"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setTestEnv(t, map[string]string{
+				c.hubVar:         c.hubName,
+				EnvDeviceID:      "foo",
+				EnvCACerts:       "roots.pem",
+				EnvCertPath:      "foo.x509",
+				EnvKeyPath:       "foo.pem",
+				EnvKeyPassphrase: "hunter2",
+			})
+
+			got, err := DeviceFromEnv()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Equal(want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDeviceFromEnvModule(t *testing.T) {
+	setTestEnv(t, map[string]string{
+		EnvName:     "myhub",
+		EnvDeviceID: "foo",
+		EnvModuleID: "sensor",
+		EnvCACerts:  "roots.pem",
+		EnvCertPath: "foo.x509",
+		EnvKeyPath:  "foo.pem",
+	})
+
+	got, err := DeviceFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ModuleID != "sensor" {
+		t.Errorf("got module ID %q, want %q", got.ModuleID, "sensor")
+	}
+}
+
+func TestDeviceFromEnvGateway(t *testing.T) {
+	setTestEnv(t, map[string]string{
+		EnvName:        "myhub",
+		EnvDeviceID:    "foo",
+		EnvGatewayHost: "edge.local",
+		EnvCACerts:     "roots.pem",
+		EnvCertPath:    "foo.x509",
+		EnvKeyPath:     "foo.pem",
+	})
+
+	got, err := DeviceFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.GatewayHostName != "edge.local" {
+		t.Errorf("got gateway host name %q, want %q", got.GatewayHostName, "edge.local")
+	}
+}
+
+func TestDeviceFromEnvSASKey(t *testing.T) {
+	setTestEnv(t, map[string]string{
+		EnvName:     "myhub",
+		EnvDeviceID: "foo",
+		EnvCACerts:  "roots.pem",
+		EnvSASKey:   "c2VjcmV0",
+	})
+
+	got, err := DeviceFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Device{HubName: "myhub", DeviceID: "foo", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0"}
+	if !got.Equal(want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDeviceFromEnvErrors(t *testing.T) {
+	valid := map[string]string{
+		EnvName:     "myhub",
+		EnvDeviceID: "foo",
+		EnvCACerts:  "roots.pem",
+		EnvCertPath: "foo.x509",
+		EnvKeyPath:  "foo.pem",
+	}
+
+	cases := []struct {
+		name    string
+		modify  func(env map[string]string)
+		wantMsg string
+	}{
+		{"no hub", func(env map[string]string) { delete(env, EnvName) }, EnvName},
+		{"host and name", func(env map[string]string) { env[EnvHost] = "myhub.azure-devices.net" }, EnvHost},
+		{"bad host", func(env map[string]string) { delete(env, EnvName); env[EnvHost] = "myhub.example.com" }, EnvHost},
+		{"no device ID", func(env map[string]string) { delete(env, EnvDeviceID) }, EnvDeviceID},
+		{"no CA certs", func(env map[string]string) { delete(env, EnvCACerts) }, EnvCACerts},
+		{"no cert", func(env map[string]string) { delete(env, EnvCertPath) }, EnvCertPath},
+		{"no key", func(env map[string]string) { delete(env, EnvKeyPath) }, EnvKeyPath},
+		{"bad SAS key", func(env map[string]string) {
+			delete(env, EnvCertPath)
+			delete(env, EnvKeyPath)
+			env[EnvSASKey] = "not base64!"
+		}, "shared access key"},
+		{"SAS key and cert", func(env map[string]string) { env[EnvSASKey] = "c2VjcmV0" }, "shared access key"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			env := make(map[string]string)
+			for k, v := range valid {
+				env[k] = v
+			}
+			c.modify(env)
+			setTestEnv(t, env)
+
+			_, err := DeviceFromEnv()
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), c.wantMsg) {
+				t.Errorf("error %q doesn't name %s", err, c.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package iothub
+
+import "errors"
+
+// Errors returned by this package. Errors that wrap them include more detail; use errors.Is to check for them.
+var (
+	// ErrCertNotFound is returned when a device cert file does not exist.
+	ErrCertNotFound = errors.New("iothub: cert file does not exist")
+
+	// ErrNoCACerts is returned when no CA certs could be parsed from the given source.
+	ErrNoCACerts = errors.New("iothub: no CA certs were parsed")
+
+	// ErrInvalidConnectionString is returned when a connection string can't be parsed or doesn't describe a device
+	// that this package can connect as.
+	ErrInvalidConnectionString = errors.New("iothub: invalid connection string")
+
+	// ErrKeyPairLoad is returned when the device's cert and private key can't be loaded.
+	ErrKeyPairLoad = errors.New("iothub: failed to load x509 key pair")
+
+	// ErrRetained is returned when publishing a retained message, which IoT Hub does not support.
+	ErrRetained = errors.New("iothub: IoT Hub does not support retained messages")
+
+	// ErrMessageTooLarge is returned when publishing a message larger than MaxMessageBytes.
+	ErrMessageTooLarge = errors.New("iothub: message exceeds 256 KB limit")
+
+	// ErrPublishTimeout is returned when the broker doesn't acknowledge a publish within the allotted time. Unlike an
+	// error from the broker, it usually means the connection is half-open and the message may or may not have arrived.
+	ErrPublishTimeout = errors.New("iothub: timed out waiting for publish to complete")
+
+	// ErrWriteTimeout is returned when a publish couldn't be handed to the connection within the client's write
+	// timeout (see WithWriteTimeout), which usually means the connection is stalled.
+	ErrWriteTimeout = errors.New("iothub: timed out writing publish to the connection")
+
+	// ErrQueueFull is returned when enqueuing a message on a PublishQueue that's at capacity.
+	ErrQueueFull = errors.New("iothub: publish queue is full")
+
+	// ErrQueueClosed is returned when enqueuing a message on a PublishQueue that's been closed.
+	ErrQueueClosed = errors.New("iothub: publish queue is closed")
+)
@@ -0,0 +1,101 @@
+package iothub
+
+import (
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// EventKind is the kind of message an Event carries.
+type EventKind int
+
+const (
+	// EventCommand is a cloud-to-device message.
+	EventCommand EventKind = iota
+	// EventTwinDesired is a desired properties update.
+	EventTwinDesired
+	// EventTwinResponse is a response to a twin request.
+	EventTwinResponse
+	// EventMethod is a direct method request.
+	EventMethod
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventCommand:
+		return "command"
+	case EventTwinDesired:
+		return "twin desired"
+	case EventTwinResponse:
+		return "twin response"
+	case EventMethod:
+		return "method"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a message received on one of the topics subscribed to by Events.
+type Event struct {
+	Kind  EventKind
+	Topic string
+	// Properties are decoded from the topic: a command's property bag, or the query parameters of the other kinds,
+	// e.g. $rid for twin responses and method requests and $version for desired properties updates.
+	Properties map[string]string
+	Payload    []byte
+}
+
+// eventBuffer is the capacity of the channel returned by Events.
+const eventBuffer = 16
+
+// Events subscribes to all of the topics on which the device receives messages, as SubscribeAll does with QoS 1, and
+// returns a channel on which each message is sent as an Event, so that a single select loop can handle them all.
+//
+// Messages are acknowledged once their event is sent, so the caller must keep receiving from the channel; while it's
+// full, the client's message handling is blocked. The channel is never closed. Twin responses to requests made with
+// GetTwin and UpdateReportedProperties, and method requests served with ServeMethods, go to those helpers rather than
+// the channel.
+func (d *Device) Events(client mqtt.Client) (<-chan Event, error) {
+	ch := make(chan Event, eventBuffer)
+	handler := func(client mqtt.Client, msg mqtt.Message) {
+		if e, ok := d.event(msg.Topic(), msg.Payload()); ok {
+			ch <- e
+		}
+	}
+
+	if err := d.SubscribeAll(client, 1, handler); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// event returns the Event for a message received on topic. It returns false if the topic isn't one that Events
+// subscribes to or it's malformed.
+func (d *Device) event(topic string, payload []byte) (Event, bool) {
+	e := Event{Topic: topic, Payload: payload}
+	switch {
+	case TopicMatches(d.CommandTopic(), topic):
+		cmd, err := d.parseCommand(topic, payload)
+		if err != nil {
+			return Event{}, false
+		}
+		e.Kind, e.Properties = EventCommand, cmd.Properties
+		return e, true
+	case strings.HasPrefix(topic, DesiredPropertiesPrefix):
+		e.Kind = EventTwinDesired
+	case strings.HasPrefix(topic, TwinResponsePrefix):
+		e.Kind = EventTwinResponse
+	case strings.HasPrefix(topic, MethodRequestPrefix):
+		e.Kind = EventMethod
+	default:
+		return Event{}, false
+	}
+
+	_, query, _ := strings.Cut(topic, "?")
+	props, err := decodePropertyBag(query)
+	if err != nil {
+		return Event{}, false
+	}
+	e.Properties = props
+	return e, true
+}
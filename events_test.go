@@ -0,0 +1,47 @@
+package iothub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	client := &fakeClient{}
+	events, err := device.Events(client)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer device.Disconnect(client, 0)
+
+	cases := []struct {
+		topic     string
+		wantKind  EventKind
+		wantProps map[string]string
+	}{
+		{"devices/foo/messages/devicebound/%24.mid=1&color=red", EventCommand, map[string]string{"$.mid": "1", "color": "red"}},
+		{"$iothub/twin/PATCH/properties/desired/?$version=5", EventTwinDesired, map[string]string{"$version": "5"}},
+		{"$iothub/twin/res/200/?$rid=7", EventTwinResponse, map[string]string{"$rid": "7"}},
+		{"$iothub/methods/POST/reboot/?$rid=8", EventMethod, map[string]string{"$rid": "8"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.wantKind.String(), func(t *testing.T) {
+			client.deliver(c.topic, []byte("payload"))
+
+			select {
+			case e := <-events:
+				if e.Kind != c.wantKind {
+					t.Errorf("got kind %v, want %v", e.Kind, c.wantKind)
+				}
+				if e.Topic != c.topic || string(e.Payload) != "payload" {
+					t.Errorf("got topic %q and payload %q, want %q and %q", e.Topic, e.Payload, c.topic, "payload")
+				}
+				if !reflect.DeepEqual(e.Properties, c.wantProps) {
+					t.Errorf("got properties %v, want %v", e.Properties, c.wantProps)
+				}
+			default:
+				t.Fatal("no event")
+			}
+		})
+	}
+}
@@ -26,10 +26,9 @@ func Example() {
 		log.Fatalf("Failed to connect to MQTT broker: %v", token.Error())
 	}
 
-	if token := client.Publish(d.TelemetryTopic(), 1, false, []byte("{\"temp\": 18.0}")); !token.Wait() || token.Error() != nil {
-		log.Printf("Failed to publish: %v", token.Error())
+	if err := d.Publish(client, d.TelemetryTopic(), 1, false, []byte("{\"temp\": 18.0}")); err != nil {
+		log.Printf("Failed to publish: %v", err)
 	}
 
-	client.Disconnect(250)
-	time.Sleep(500 * time.Millisecond)
+	d.Disconnect(client, 250*time.Millisecond)
 }
@@ -0,0 +1,214 @@
+package iothub
+
+import (
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeToken is an mqtt.Token that is already complete.
+type fakeToken struct {
+	err  error
+	done chan struct{}
+}
+
+func newFakeToken(err error) *fakeToken {
+	t := &fakeToken{err: err, done: make(chan struct{})}
+	close(t.done)
+	return t
+}
+
+// newPendingFakeToken returns a fakeToken that never completes.
+func newPendingFakeToken() *fakeToken {
+	return &fakeToken{done: make(chan struct{})}
+}
+
+func (t *fakeToken) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *fakeToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-t.done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (t *fakeToken) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *fakeToken) Error() error {
+	return t.err
+}
+
+// fakeMessage is an mqtt.Message.
+type fakeMessage struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+	acked    bool
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return m.qos }
+func (m *fakeMessage) Retained() bool    { return m.retained }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return 0 }
+func (m *fakeMessage) Payload() []byte   { return m.payload }
+func (m *fakeMessage) Ack()              { m.acked = true }
+
+// fakeClient is an mqtt.Client that records what it's asked to do instead of talking to a broker.
+type fakeClient struct {
+	mu            sync.Mutex
+	connected     bool
+	published     []*fakeMessage
+	subscriptions map[string]mqtt.MessageHandler
+	unsubscribed  []string
+
+	// subscribeMultipleFilters records the filters passed to each call to SubscribeMultiple.
+	subscribeMultipleFilters []map[string]byte
+
+	// calls records the names of the connection-related methods called, in order.
+	calls []string
+
+	// connectErrs are returned, in order, by calls to Connect. Once they're used up Connect succeeds.
+	connectErrs []error
+
+	// publishErr is the error returned by the token from each call to Publish. If publishPending is true the token
+	// never completes instead.
+	publishErr     error
+	publishPending bool
+
+	// onPublish, if set, is called after each publish. Use it to respond to requests by calling deliver.
+	onPublish func(c *fakeClient, topic string, payload []byte)
+
+	// onSubscribe, if set, is called after each call to Subscribe. Use it to deliver messages to a new subscription.
+	onSubscribe func(c *fakeClient, topic string)
+
+	// cleanSession makes Disconnect drop the client's subscriptions, as the broker does with a clean session.
+	cleanSession bool
+}
+
+// deliver passes a message to the handler of each subscription that matches the topic.
+func (c *fakeClient) deliver(topic string, payload []byte) {
+	c.mu.Lock()
+	var handlers []mqtt.MessageHandler
+	for filter, h := range c.subscriptions {
+		if TopicMatches(filter, topic) {
+			handlers = append(handlers, h)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, h := range handlers {
+		h(c, &fakeMessage{topic: topic, payload: payload})
+	}
+}
+
+func (c *fakeClient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *fakeClient) IsConnectionOpen() bool {
+	return c.IsConnected()
+}
+
+func (c *fakeClient) Connect() mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, "Connect")
+	if len(c.connectErrs) > 0 {
+		err := c.connectErrs[0]
+		c.connectErrs = c.connectErrs[1:]
+		return newFakeToken(err)
+	}
+	c.connected = true
+	return newFakeToken(nil)
+}
+
+func (c *fakeClient) Disconnect(quiesce uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, "Disconnect")
+	c.connected = false
+	if c.cleanSession {
+		c.subscriptions = nil
+	}
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	var b []byte
+	switch p := payload.(type) {
+	case []byte:
+		b = p
+	case string:
+		b = []byte(p)
+	}
+
+	c.mu.Lock()
+	c.published = append(c.published, &fakeMessage{topic: topic, qos: qos, retained: retained, payload: b})
+	onPublish := c.onPublish
+	err, pending := c.publishErr, c.publishPending
+	c.mu.Unlock()
+
+	if onPublish != nil {
+		onPublish(c, topic, b)
+	}
+	if pending {
+		return newPendingFakeToken()
+	}
+	return newFakeToken(err)
+}
+
+func (c *fakeClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]mqtt.MessageHandler)
+	}
+	c.subscriptions[topic] = callback
+	onSubscribe := c.onSubscribe
+	c.mu.Unlock()
+
+	if onSubscribe != nil {
+		onSubscribe(c, topic)
+	}
+	return newFakeToken(nil)
+}
+
+func (c *fakeClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]mqtt.MessageHandler)
+	}
+	for topic := range filters {
+		c.subscriptions[topic] = callback
+	}
+	c.subscribeMultipleFilters = append(c.subscribeMultipleFilters, filters)
+	return newFakeToken(nil)
+}
+
+func (c *fakeClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, "Unsubscribe")
+	for _, topic := range topics {
+		delete(c.subscriptions, topic)
+	}
+	c.unsubscribed = append(c.unsubscribed, topics...)
+	return newFakeToken(nil)
+}
+
+func (c *fakeClient) AddRoute(topic string, callback mqtt.MessageHandler) {}
+
+func (c *fakeClient) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.ClientOptionsReader{}
+}
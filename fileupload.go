@@ -0,0 +1,96 @@
+package iothub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// fileUploadAPIVersion is the IoT Hub REST API version used for file uploads.
+const fileUploadAPIVersion = "2021-04-12"
+
+// FileUploadSAS is the information IoT Hub returns for uploading a file to the Azure Storage account associated with
+// the hub. The blob's SAS URI is https://{HostName}/{ContainerName}/{BlobName}{SASToken}.
+type FileUploadSAS struct {
+	// CorrelationID identifies the upload. Use it when notifying IoT Hub that the upload is complete.
+	CorrelationID string `json:"correlationId"`
+	HostName      string `json:"hostName"`
+	ContainerName string `json:"containerName"`
+	BlobName      string `json:"blobName"`
+	SASToken      string `json:"sasToken"`
+}
+
+// URI returns the blob's SAS URI, to which the file may be uploaded.
+func (s FileUploadSAS) URI() string {
+	return fmt.Sprintf("https://%s/%s/%s%s", s.HostName, s.ContainerName, s.BlobName, s.SASToken)
+}
+
+// RequestFileUploadSASURI asks IoT Hub for a SAS URI to which the device can upload a file with the given blob name.
+// This is the first step of a file upload; IoT Hub uses HTTPS rather than MQTT for it.
+//
+// A device with a SharedAccessKey authenticates with a shared access signature, valid for DefaultSASTokenTTL, sent in
+// the request's Authorization header. Otherwise the device authenticates with its cert, so httpClient's transport must
+// be configured with the device's TLS config (see NewTLSConfig). If httpClient is nil, a client with that
+// configuration is used.
+//
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-devguide-file-upload.
+func (d *Device) RequestFileUploadSASURI(ctx context.Context, httpClient *http.Client, blobName string) (FileUploadSAS, error) {
+	if httpClient == nil {
+		tlsConf, err := d.NewTLSConfig()
+		if err != nil {
+			return FileUploadSAS{}, err
+		}
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}
+	}
+
+	return d.requestFileUploadSASURI(ctx, httpClient, "https://"+d.hubHost(), blobName)
+}
+
+// requestFileUploadSASURI is like RequestFileUploadSASURI but sends the request to the given base URL.
+func (d *Device) requestFileUploadSASURI(ctx context.Context, httpClient *http.Client, baseURL string, blobName string) (FileUploadSAS, error) {
+	body, err := json.Marshal(struct {
+		BlobName string `json:"blobName"`
+	}{blobName})
+	if err != nil {
+		return FileUploadSAS{}, err
+	}
+
+	u := fmt.Sprintf("%s/devices/%s/files?api-version=%s", baseURL, url.PathEscape(d.DeviceID), fileUploadAPIVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return FileUploadSAS{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.SharedAccessKey != "" {
+		token, err := d.SASToken(DefaultSASTokenTTL)
+		if err != nil {
+			return FileUploadSAS{}, err
+		}
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return FileUploadSAS{}, fmt.Errorf("iothub: file upload SAS URI request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return FileUploadSAS{}, fmt.Errorf("iothub: failed to read file upload SAS URI response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return FileUploadSAS{}, fmt.Errorf("iothub: file upload SAS URI request failed with status %d: %s", resp.StatusCode, respBody)
+	}
+
+	var sas FileUploadSAS
+	if err := json.Unmarshal(respBody, &sas); err != nil {
+		return FileUploadSAS{}, fmt.Errorf("iothub: malformed file upload SAS URI response: %v", err)
+	}
+
+	return sas, nil
+}
@@ -0,0 +1,91 @@
+package iothub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestFileUploadSASURI(t *testing.T) {
+	want := FileUploadSAS{
+		CorrelationID: "abc123",
+		HostName:      "mystorage.blob.core.windows.net",
+		ContainerName: "uploads",
+		BlobName:      "foo/log.txt",
+		SASToken:      "?sv=2018-03-28&sr=b&sig=xyz",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("got method %s, want POST", r.Method)
+		}
+		if got, want := r.URL.String(), "/devices/foo/files?api-version=2021-04-12"; got != want {
+			t.Errorf("got URL %q, want %q", got, want)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("got Content-Type %q, want application/json", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("got Authorization %q for a device that authenticates with its cert, want none", got)
+		}
+
+		var body struct {
+			BlobName string `json:"blobName"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		if body.BlobName != "log.txt" {
+			t.Errorf("got blob name %q, want %q", body.BlobName, "log.txt")
+		}
+
+		json.NewEncoder(w).Encode(want)
+	}))
+	defer server.Close()
+
+	got, err := device.requestFileUploadSASURI(context.Background(), server.Client(), server.URL, "log.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	wantURI := "https://mystorage.blob.core.windows.net/uploads/foo/log.txt?sv=2018-03-28&sr=b&sig=xyz"
+	if got.URI() != wantURI {
+		t.Errorf("got URI %q, want %q", got.URI(), wantURI)
+	}
+}
+
+func TestRequestFileUploadSASURISharedAccessKey(t *testing.T) {
+	clock := time.Unix(1700000000, 0).Add(-DefaultSASTokenTTL)
+	fakeNow(t, &clock)
+
+	d := Device{HubName: "myhub", DeviceID: "foo", SharedAccessKey: "c2VjcmV0"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := "SharedAccessSignature sr=myhub.azure-devices.net%2Fdevices%2Ffoo&sig=pV6uK5vKl7p9tPkFuwt1vn%2BL1WO5qBuUx9OvrSfw3VI%3D&se=1700000000"
+		if got := r.Header.Get("Authorization"); got != want {
+			t.Errorf("got Authorization %q, want %q", got, want)
+		}
+		json.NewEncoder(w).Encode(FileUploadSAS{CorrelationID: "abc123"})
+	}))
+	defer server.Close()
+
+	if _, err := d.requestFileUploadSASURI(context.Background(), server.Client(), server.URL, "log.txt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRequestFileUploadSASURIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"Message":"storage account not configured"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if _, err := device.requestFileUploadSASURI(context.Background(), server.Client(), server.URL, "log.txt"); err == nil {
+		t.Error("expected error for non-200 response")
+	}
+}
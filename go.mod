@@ -1,11 +1,19 @@
 module github.com/mtraver/iothub
 
-go 1.20
+go 1.21
 
-require github.com/eclipse/paho.mqtt.golang v1.4.2
+require (
+	github.com/ThalesIgnite/crypto11 v1.2.5
+	github.com/eclipse/paho.mqtt.golang v1.4.2
+	github.com/google/go-tpm v0.9.0
+	golang.org/x/net v0.9.0
+)
 
 require (
 	github.com/gorilla/websocket v1.5.0 // indirect
-	golang.org/x/net v0.9.0 // indirect
+	github.com/miekg/pkcs11 v1.1.1 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/thales-e-security/pool v0.0.2 // indirect
 	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 )
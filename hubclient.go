@@ -0,0 +1,168 @@
+package iothub
+
+import (
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Metrics receives measurements from a HubClient. Implement it to export connection and publish metrics to a
+// monitoring system, e.g. with counters and histograms from a Prometheus client library.
+//
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// IncPublish is called after each successful publish.
+	IncPublish(topic string)
+	// IncConnect is called after each successful connect.
+	IncConnect()
+	// IncDisconnect is called after each disconnect.
+	IncDisconnect()
+	// ObservePublishLatency is called after each successful publish with the time it took to complete.
+	ObservePublishLatency(d time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) IncPublish(topic string)               {}
+func (nopMetrics) IncConnect()                           {}
+func (nopMetrics) IncDisconnect()                        {}
+func (nopMetrics) ObservePublishLatency(d time.Duration) {}
+
+// HubClient is a higher-level client for a Device. It wraps an MQTT client and uses the Device's helpers to
+// connect, publish telemetry, and disconnect, recording metrics along the way.
+type HubClient struct {
+	Device *Device
+	Client mqtt.Client
+	// Metrics receives measurements of the client's activity. If nil, no metrics are recorded.
+	Metrics Metrics
+}
+
+// NewHubClient creates an MQTT client for the device with NewClient, passing along the given options, and wraps it in
+// a HubClient.
+func (d *Device) NewHubClient(options ...func(*Device, *mqtt.ClientOptions) error) (*HubClient, error) {
+	client, err := d.NewClient(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HubClient{Device: d, Client: client}, nil
+}
+
+func (c *HubClient) metrics() Metrics {
+	if c.Metrics == nil {
+		return nopMetrics{}
+	}
+	return c.Metrics
+}
+
+// Connect connects to the broker and waits for the connection to complete.
+func (c *HubClient) Connect() error {
+	token := c.Client.Connect()
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("iothub: failed to connect: %w", connectError(err))
+	}
+
+	c.metrics().IncConnect()
+	return nil
+}
+
+// Publish publishes payload to the device's telemetry topic with the device's DefaultQoS and waits for the publish to
+// complete.
+func (c *HubClient) Publish(payload []byte) error {
+	return c.PublishWithProperties(payload, TelemetryProperties{})
+}
+
+// PublishWithProperties is like Publish but the message has the given properties.
+func (c *HubClient) PublishWithProperties(payload []byte, props TelemetryProperties) error {
+	qos, err := c.Device.defaultQoS()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := c.Device.Publish(c.Client, c.Device.TelemetryTopicWithProperties(props), qos, false, payload); err != nil {
+		return err
+	}
+
+	c.recordPublish(start)
+	return nil
+}
+
+// PublishMessage is like PublishWithProperties but publishes msg, compressing its payload if msg.Gzip is set.
+func (c *HubClient) PublishMessage(msg Message) error {
+	msg, err := msg.encoded()
+	if err != nil {
+		return err
+	}
+	return c.PublishWithProperties(msg.Payload, msg.Properties)
+}
+
+// PublishWithTimeout is like Publish but the message has the given custom properties and it waits at most timeout
+// for the broker to acknowledge the publish. If the broker doesn't, it returns ErrPublishTimeout.
+func (c *HubClient) PublishWithTimeout(payload []byte, props map[string]string, timeout time.Duration) error {
+	qos, err := c.Device.defaultQoS()
+	if err != nil {
+		return err
+	}
+
+	topic := c.Device.TelemetryTopicWithProperties(TelemetryProperties{Custom: props})
+	if err := c.Device.checkPublish(topic, false, payload); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	token := c.Client.Publish(topic, qos, false, payload)
+	if !token.WaitTimeout(timeout) {
+		return ErrPublishTimeout
+	}
+	if err := publishError(token.Error()); err != nil {
+		return err
+	}
+
+	c.recordPublish(start)
+	return nil
+}
+
+// PublishAsync is like PublishWithTimeout but it doesn't wait for the publish to complete. Instead onAck is called
+// from a new goroutine when it completes, with nil if the broker acknowledged the message or the error otherwise.
+// Errors that prevent publishing, such as the message being too large, are returned directly and onAck isn't called.
+func (c *HubClient) PublishAsync(payload []byte, props map[string]string, onAck func(err error)) error {
+	qos, err := c.Device.defaultQoS()
+	if err != nil {
+		return err
+	}
+
+	topic := c.Device.TelemetryTopicWithProperties(TelemetryProperties{Custom: props})
+	if err := c.Device.checkPublish(topic, false, payload); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	token := c.Client.Publish(topic, qos, false, payload)
+	go func() {
+		<-token.Done()
+		err := publishError(token.Error())
+		if err == nil {
+			c.recordPublish(start)
+		}
+		onAck(err)
+	}()
+
+	return nil
+}
+
+func (c *HubClient) recordPublish(start time.Time) {
+	// Report the topic without the property bag. Property values such as message IDs are often unique per message
+	// and would make for unbounded metric cardinality.
+	m := c.metrics()
+	m.IncPublish(c.Device.TelemetryTopic())
+	m.ObservePublishLatency(time.Since(start))
+}
+
+// Disconnect disconnects from the broker as described by Device.Disconnect.
+func (c *HubClient) Disconnect(quiesce time.Duration) {
+	c.Device.Disconnect(c.Client, quiesce)
+	c.metrics().IncDisconnect()
+}
@@ -0,0 +1,285 @@
+package iothub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+type fakeMetrics struct {
+	mu        sync.Mutex
+	calls     []string
+	publishes []string
+	latencies []time.Duration
+}
+
+func (m *fakeMetrics) IncPublish(topic string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "IncPublish")
+	m.publishes = append(m.publishes, topic)
+}
+
+func (m *fakeMetrics) IncConnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "IncConnect")
+}
+
+func (m *fakeMetrics) IncDisconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "IncDisconnect")
+}
+
+func (m *fakeMetrics) ObservePublishLatency(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "ObservePublishLatency")
+	m.latencies = append(m.latencies, d)
+}
+
+func TestHubClientMetrics(t *testing.T) {
+	m := &fakeMetrics{}
+	c := &HubClient{
+		Device:  &device,
+		Client:  &fakeClient{},
+		Metrics: m,
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Publish([]byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Disconnect(0)
+
+	wantCalls := []string{"IncConnect", "IncPublish", "ObservePublishLatency", "IncDisconnect"}
+	if !reflect.DeepEqual(m.calls, wantCalls) {
+		t.Errorf("got calls %v, want %v", m.calls, wantCalls)
+	}
+
+	wantPublishes := []string{device.TelemetryTopic()}
+	if !reflect.DeepEqual(m.publishes, wantPublishes) {
+		t.Errorf("got publishes %v, want %v", m.publishes, wantPublishes)
+	}
+}
+
+func TestHubClientNilMetrics(t *testing.T) {
+	c := &HubClient{
+		Device: &device,
+		Client: &fakeClient{},
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Publish([]byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Disconnect(0)
+}
+
+func TestHubClientPublishWithProperties(t *testing.T) {
+	client := &fakeClient{}
+	c := &HubClient{Device: &device, Client: client}
+
+	if err := c.PublishWithProperties([]byte("{}"), TelemetryProperties{ContentType: "application/json"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "devices/foo/messages/events/%24.ct=application%2Fjson"
+	if len(client.published) != 1 || client.published[0].topic != want {
+		t.Errorf("got published %v, want one message to %q", client.published, want)
+	}
+}
+
+func TestHubClientPublishWithExpiry(t *testing.T) {
+	client := &fakeClient{}
+	c := &HubClient{Device: &device, Client: client}
+
+	props := TelemetryProperties{Expiry: time.Date(2024, 3, 1, 20, 30, 0, 0, time.UTC)}
+	if err := c.PublishWithProperties([]byte("{}"), props); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "devices/foo/messages/events/%24.exp=2024-03-01T20%3A30%3A00Z"
+	if len(client.published) != 1 || client.published[0].topic != want {
+		t.Errorf("got published %v, want one message to %q", client.published, want)
+	}
+}
+
+func TestHubClientPublishMessageGzip(t *testing.T) {
+	client := &fakeClient{}
+	c := &HubClient{Device: &device, Client: client}
+
+	// The payload is over the size limit before compression but well under it after.
+	payload := bytes.Repeat([]byte(`{"temp":21.5}`), MaxMessageBytes/10)
+	msg := Message{Payload: payload, Properties: TelemetryProperties{ContentType: "application/json"}, Gzip: true}
+	if err := c.PublishMessage(msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(client.published) != 1 {
+		t.Fatalf("got %d published messages, want 1", len(client.published))
+	}
+	want := "devices/foo/messages/events/%24.ct=application%2Fjson&%24.ce=gzip"
+	if got := client.published[0].topic; got != want {
+		t.Errorf("got topic %q, want %q", got, want)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(client.published[0].payload))
+	if err != nil {
+		t.Fatalf("published payload isn't gzipped: %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress published payload: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("decompressed payload doesn't match the original")
+	}
+}
+
+func TestHubClientDefaultQoS(t *testing.T) {
+	qos0, qos2 := byte(0), byte(2)
+	cases := []struct {
+		name       string
+		defaultQoS *byte
+		wantQoS    byte
+		wantErr    bool
+	}{
+		{"unset", nil, 1, false},
+		{"QoS 0", &qos0, 0, false},
+		{"QoS 2", &qos2, 0, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := device
+			d.DefaultQoS = c.defaultQoS
+			client := &fakeClient{}
+			hc := &HubClient{Device: &d, Client: client}
+
+			err := hc.Publish([]byte("hello"))
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, c.wantErr)
+			}
+			if c.wantErr {
+				if len(client.published) != 0 {
+					t.Errorf("got %d published messages, want 0", len(client.published))
+				}
+				return
+			}
+
+			if got := client.published[0].qos; got != c.wantQoS {
+				t.Errorf("got QoS %d, want %d", got, c.wantQoS)
+			}
+		})
+	}
+}
+
+func TestHubClientPublishWithTimeout(t *testing.T) {
+	errBroker := errors.New("broker error")
+	cases := []struct {
+		name    string
+		client  *fakeClient
+		wantErr error
+	}{
+		{"acked", &fakeClient{}, nil},
+		{"broker error", &fakeClient{publishErr: errBroker}, errBroker},
+		{"timeout", &fakeClient{publishPending: true}, ErrPublishTimeout},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &fakeMetrics{}
+			hc := &HubClient{Device: &device, Client: c.client, Metrics: m}
+
+			err := hc.PublishWithTimeout([]byte("hello"), map[string]string{"a": "b"}, 10*time.Millisecond)
+			if err != c.wantErr {
+				t.Fatalf("got error %v, want %v", err, c.wantErr)
+			}
+
+			if got, want := c.client.published[0].topic, "devices/foo/messages/events/a=b"; got != want {
+				t.Errorf("got topic %q, want %q", got, want)
+			}
+			wantPublishes := 0
+			if c.wantErr == nil {
+				wantPublishes = 1
+			}
+			if got := len(m.publishes); got != wantPublishes {
+				t.Errorf("got %d recorded publishes, want %d", got, wantPublishes)
+			}
+		})
+	}
+}
+
+func TestHubClientPublishAsync(t *testing.T) {
+	errBroker := errors.New("broker error")
+	cases := []struct {
+		name    string
+		client  *fakeClient
+		wantErr error
+	}{
+		{"acked", &fakeClient{}, nil},
+		{"broker error", &fakeClient{publishErr: errBroker}, errBroker},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hc := &HubClient{Device: &device, Client: c.client}
+
+			acks := make(chan error, 1)
+			err := hc.PublishAsync([]byte("hello"), map[string]string{"a": "b"}, func(err error) {
+				acks <- err
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			select {
+			case err := <-acks:
+				if err != c.wantErr {
+					t.Errorf("onAck got error %v, want %v", err, c.wantErr)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("onAck wasn't called")
+			}
+		})
+	}
+}
+
+func TestHubClientPublishAsyncTooLarge(t *testing.T) {
+	hc := &HubClient{Device: &device, Client: &fakeClient{}}
+	err := hc.PublishAsync(make([]byte, MaxMessageBytes+1), nil, func(err error) {
+		t.Error("onAck called for a message that wasn't published")
+	})
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("got error %v, want %v", err, ErrMessageTooLarge)
+	}
+}
+
+func TestHubClientConnectServerUnavailable(t *testing.T) {
+	c := &HubClient{
+		Device: &device,
+		Client: &fakeClient{connectErrs: []error{packets.ErrorRefusedServerUnavailable}},
+	}
+
+	err := c.Connect()
+	if !errors.Is(err, packets.ErrorRefusedServerUnavailable) {
+		t.Errorf("got error %v, want %v", err, packets.ErrorRefusedServerUnavailable)
+	}
+	if err == nil || !strings.Contains(err.Error(), "api-version") {
+		t.Errorf("error %v has no hint", err)
+	}
+}
@@ -7,48 +7,324 @@ package iothub
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
-const azureDevicesEndpoint = "azure-devices.net"
+// newMQTTClient creates the clients returned by NewClient. It's a variable so that tests can replace it.
+var newMQTTClient = mqtt.NewClient
+
+const (
+	azureDevicesEndpoint = "azure-devices.net"
+
+	// pnpAPIVersion is the earliest API version that supports IoT Plug and Play.
+	pnpAPIVersion = "2020-09-30"
+)
 
 // DeviceIDFromCert gets the Common Name from an X.509 cert, which for the purposes of this package is considered to be the device ID.
+// If the file holds a cert chain, as needed when the device's cert is issued by an intermediate CA, the leaf (first)
+// cert is used.
 func DeviceIDFromCert(certPath string) (string, error) {
-	certBytes, err := ioutil.ReadFile(certPath)
+	return DeviceIDFromCertWithExtractor(certPath, CommonNameExtractor)
+}
+
+// DeviceIDFromCertWithExtractor is like DeviceIDFromCert but uses extract to get the device ID from the cert. Use it
+// with DNSNameExtractor for certs that identify the device with a Subject Alternative Name rather than the Common Name,
+// or supply your own extractor.
+func DeviceIDFromCertWithExtractor(certPath string, extract func(*x509.Certificate) (string, error)) (string, error) {
+	f, err := os.Open(certPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "", fmt.Errorf("iothub: cert file does not exist: %v", certPath)
+			return "", fmt.Errorf("%w: %v", ErrCertNotFound, certPath)
 		}
 
 		return "", fmt.Errorf("iothub: failed to read cert: %v", err)
 	}
+	defer f.Close()
 
-	block, _ := pem.Decode(certBytes)
-	if block == nil || block.Type != "CERTIFICATE" {
-		return "", fmt.Errorf("iothub: failed to decode PEM certificate")
+	cert, err := readCert(f)
+	if err != nil {
+		return "", err
 	}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
+	return extract(cert)
+}
+
+// DeviceIDFromCertReader is like DeviceIDFromCert but reads the PEM-encoded cert from r rather than from a file.
+func DeviceIDFromCertReader(r io.Reader) (string, error) {
+	cert, err := readCert(r)
 	if err != nil {
 		return "", err
 	}
 
+	return CommonNameExtractor(cert)
+}
+
+// CommonNameExtractor returns the cert's Common Name. It's the extractor used by DeviceIDFromCert.
+func CommonNameExtractor(cert *x509.Certificate) (string, error) {
 	return cert.Subject.CommonName, nil
 }
 
+// DNSNameExtractor returns the cert's first DNS Subject Alternative Name. It returns an error if the cert has none.
+func DNSNameExtractor(cert *x509.Certificate) (string, error) {
+	if len(cert.DNSNames) == 0 {
+		return "", fmt.Errorf("iothub: cert has no DNS Subject Alternative Name")
+	}
+
+	return cert.DNSNames[0], nil
+}
+
+// readCert reads and parses a PEM-encoded X.509 cert. If the input holds a chain, the first cert, which is the leaf,
+// is returned. Other PEM blocks, such as a private key in a combined PEM file, are skipped.
+func readCert(r io.Reader) (*x509.Certificate, error) {
+	certBytes, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("iothub: failed to read cert: %v", err)
+	}
+
+	for rest := certBytes; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("iothub: failed to decode PEM certificate")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
 // Device represents an IoT Hub device.
 type Device struct {
 	HubName  string `json:"hub_name"`
 	DeviceID string `json:"device_id"`
+	// ModuleID, if set, makes the client connect as the module with this ID in the device rather than as the device
+	// itself, e.g. for an IoT Edge module. A module has its own twin and direct methods, which use the same topics as
+	// a device's because the hub scopes them to the identity that connected. Modules can't receive cloud-to-device
+	// messages, so CommandTopic and NextCommand don't apply to them.
+	// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-devguide-module-twins.
+	ModuleID string `json:"module_id,omitempty"`
+	// GatewayHostName, if set, is the host name of an IoT Edge gateway through which the device connects instead of
+	// connecting to the hub directly, as given by the GatewayHostName field of its connection string. The device still
+	// authenticates as an identity in the hub named by HubName. CACerts must include the gateway's root CA cert.
+	// See https://learn.microsoft.com/en-us/azure/iot-edge/how-to-connect-downstream-device.
+	GatewayHostName string `json:"gateway_host_name,omitempty"`
 	// CACerts must contain the path to a .pem file containing Azure's trusted root certs. See the README for more info.
 	CACerts     string `json:"ca_certs_path"`
 	CertPath    string `json:"cert_path"`
 	PrivKeyPath string `json:"priv_key_path"`
+	// CombinedPEMPath is the path to a single PEM file containing both the device's cert and its private key, as some
+	// provisioning tools produce. If it's set, CertPath and PrivKeyPath must be empty.
+	CombinedPEMPath string `json:"combined_pem_path,omitempty"`
+	// CertPEM and KeyPEM are the device's PEM-encoded cert and private key, for when they come from a secret manager
+	// or are embedded in the binary rather than being stored in files. If they're set, CertPath, PrivKeyPath, and
+	// CombinedPEMPath must be empty. KeyPassphrase applies to KeyPEM as it does to a key file.
+	CertPEM string `json:"cert_pem,omitempty"`
+	KeyPEM  string `json:"key_pem,omitempty"`
+	// ClientCert is the device's cert and private key, already loaded. It's an alternative to the paths and PEM
+	// fields for callers that build the tls.Certificate themselves. If it's set, they must be empty.
+	ClientCert *tls.Certificate `json:"-"`
+	// KeyPassphrase is the passphrase for the private key, if it's encrypted. Only keys encrypted with legacy PEM
+	// encryption (RFC 1423, i.e. a PEM block with a "Proc-Type: 4,ENCRYPTED" header) are supported; encrypted PKCS #8
+	// keys are not.
+	KeyPassphrase string `json:"key_passphrase"`
+	// SharedAccessKey is the device's (or module's) base64-encoded symmetric key, as shown in the Azure portal. If
+	// it's set the device authenticates with a shared access signature generated from it rather than with a cert, and
+	// CertPath, PrivKeyPath, and CombinedPEMPath must be empty. CACerts is still required to verify the hub.
+	// See https://learn.microsoft.com/en-us/azure/iot-hub/authenticate-authorize-sas.
+	SharedAccessKey string `json:"shared_access_key,omitempty"`
+	// ClientIDOverride, if set, is used as the MQTT client ID instead of DeviceID. IoT Hub generally requires the
+	// client ID to be the device ID and refuses connections otherwise, so this is an escape hatch for advanced uses
+	// like testing against other brokers or running a shadow connection. Most users should leave it empty.
+	ClientIDOverride string `json:"client_id_override,omitempty"`
+	// ModelID is the DTDL model ID that an IoT Plug and Play device advertises when it connects, e.g.
+	// "dtmi:com:example:Thermostat;1". See https://learn.microsoft.com/en-us/azure/iot-develop/concepts-developer-guide-device.
+	ModelID string `json:"model_id,omitempty"`
+	// DefaultQoS is the QoS with which the package's publish helpers, such as HubClient.Publish, publish telemetry. It
+	// must be 0 or 1; IoT Hub doesn't support QoS 2. If nil, QoS 1 is used.
+	DefaultQoS *byte `json:"default_qos,omitempty"`
+	// TelemetryTopicTemplate, if set, is used by TelemetryTopic instead of the standard telemetry topic, for routing
+	// setups that expect telemetry on a particular topic. The placeholder {deviceID} is replaced with DeviceID, and
+	// {moduleID} with ModuleID. Most users should leave it empty.
+	TelemetryTopicTemplate string `json:"telemetry_topic_template,omitempty"`
+
+	// creds holds the client cert used in TLS handshakes so that ReloadCredentials can replace it.
+	creds *credentials
+}
+
+// Validate checks that the device's configuration is complete. It doesn't check that the files it refers to exist.
+//
+// HubName must be the hub's short name, e.g. "myhub" rather than "myhub.azure-devices.net". As a convenience, Validate
+// strips a trailing ".azure-devices.net" from HubName; any other character that isn't a letter, digit, or hyphen is
+// an error.
+func (d *Device) Validate() error {
+	d.HubName = strings.TrimSuffix(d.HubName, "."+azureDevicesEndpoint)
+	if d.HubName == "" {
+		return fmt.Errorf("iothub: hub name is required")
+	}
+	for i, r := range d.HubName {
+		switch {
+		case r == '.':
+			return fmt.Errorf("iothub: hub name %q must be the hub's short name, not a host name: use %q", d.HubName, d.HubName[:i])
+		case !isHubNameRune(r):
+			return fmt.Errorf("iothub: hub name %q contains illegal character %q; only letters, digits, and hyphens are allowed", d.HubName, r)
+		}
+	}
+	if d.DeviceID == "" {
+		return fmt.Errorf("iothub: device ID is required")
+	}
+	if d.CACerts == "" {
+		return fmt.Errorf("iothub: CA certs path is required")
+	}
+	if err := d.validateCredentials(); err != nil {
+		return err
+	}
+	if _, err := d.defaultQoS(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateCredentials checks that the device has exactly one source of credentials: cert and private key paths, a
+// combined PEM path, cert and private key PEM, a client cert, or a shared access key.
+func (d *Device) validateCredentials() error {
+	var sources []string
+	if d.CertPath != "" || d.PrivKeyPath != "" {
+		sources = append(sources, "cert and private key paths")
+	}
+	if d.CombinedPEMPath != "" {
+		sources = append(sources, "a combined PEM path")
+	}
+	if d.CertPEM != "" || d.KeyPEM != "" {
+		sources = append(sources, "cert and private key PEM")
+	}
+	if d.ClientCert != nil {
+		sources = append(sources, "a client cert")
+	}
+	if d.SharedAccessKey != "" {
+		sources = append(sources, "a shared access key")
+	}
+	if len(sources) > 1 {
+		return fmt.Errorf("iothub: only one of %s may be given", strings.Join(sources, ", "))
+	}
+
+	switch {
+	case d.SharedAccessKey != "":
+		if _, err := base64.StdEncoding.DecodeString(d.SharedAccessKey); err != nil {
+			return fmt.Errorf("iothub: shared access key is not valid base64: %v", err)
+		}
+	case d.CombinedPEMPath != "", d.ClientCert != nil:
+	case d.CertPEM != "" || d.KeyPEM != "":
+		if d.CertPEM == "" {
+			return fmt.Errorf("iothub: cert PEM is required when private key PEM is given")
+		}
+		if d.KeyPEM == "" {
+			return fmt.Errorf("iothub: private key PEM is required when cert PEM is given")
+		}
+	case d.CertPath == "" && d.PrivKeyPath == "":
+		return fmt.Errorf("iothub: cert path and private key path, or a shared access key, are required")
+	case d.CertPath == "":
+		return fmt.Errorf("iothub: cert path is required when a private key path is given")
+	case d.PrivKeyPath == "":
+		return fmt.Errorf("iothub: private key path is required when a cert path is given")
+	}
+
+	return nil
+}
+
+// isHubNameRune reports whether r may appear in an IoT Hub name.
+func isHubNameRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-'
+}
+
+// String returns a description of the device that's safe to log: it includes the hub name, device ID, and cert path,
+// but not the private key path or PEM, and the key passphrase and shared access key are redacted. Because Device
+// implements fmt.Stringer, this is also what's printed when a Device is formatted with verbs such as %v and %+v.
+func (d Device) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Device{HubName: %q, DeviceID: %q", d.HubName, d.DeviceID)
+	if d.ModuleID != "" {
+		fmt.Fprintf(&b, ", ModuleID: %q", d.ModuleID)
+	}
+	if d.GatewayHostName != "" {
+		fmt.Fprintf(&b, ", GatewayHostName: %q", d.GatewayHostName)
+	}
+	fmt.Fprintf(&b, ", CertPath: %q", d.CertPath)
+	if d.KeyPassphrase != "" {
+		b.WriteString(`, KeyPassphrase: "[redacted]"`)
+	}
+	if d.SharedAccessKey != "" {
+		b.WriteString(`, SharedAccessKey: "[redacted]"`)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// plainDevice has Device's fields but not its methods, so that marshaling it doesn't call Device.MarshalJSON.
+type plainDevice Device
+
+// MarshalJSON encodes the device as JSON without its secrets, i.e. KeyPEM, KeyPassphrase, and SharedAccessKey, so that
+// a Device can be persisted or logged without leaking them. Use MarshalJSONWithSecrets to include them.
+func (d Device) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		plainDevice
+		KeyPEM          string `json:"key_pem,omitempty"`
+		KeyPassphrase   string `json:"key_passphrase,omitempty"`
+		SharedAccessKey string `json:"shared_access_key,omitempty"`
+	}{plainDevice: plainDevice(d)})
+}
+
+// MarshalJSONWithSecrets encodes the device as JSON including its secrets, for when the caller intends to store the
+// full credentials, e.g. in a secure store. The result can be decoded with json.Unmarshal.
+func (d Device) MarshalJSONWithSecrets() ([]byte, error) {
+	return json.Marshal(plainDevice(d))
+}
+
+// Equal reports whether d and other have the same configuration. State derived from the configuration, such as a
+// loaded cert, is ignored. ClientCert is compared by pointer.
+func (d Device) Equal(other Device) bool {
+	qosEqual := d.DefaultQoS == other.DefaultQoS ||
+		(d.DefaultQoS != nil && other.DefaultQoS != nil && *d.DefaultQoS == *other.DefaultQoS)
+
+	return d.HubName == other.HubName &&
+		d.DeviceID == other.DeviceID &&
+		d.ModuleID == other.ModuleID &&
+		d.GatewayHostName == other.GatewayHostName &&
+		d.CACerts == other.CACerts &&
+		d.CertPath == other.CertPath &&
+		d.PrivKeyPath == other.PrivKeyPath &&
+		d.CombinedPEMPath == other.CombinedPEMPath &&
+		d.CertPEM == other.CertPEM &&
+		d.KeyPEM == other.KeyPEM &&
+		d.ClientCert == other.ClientCert &&
+		d.KeyPassphrase == other.KeyPassphrase &&
+		d.SharedAccessKey == other.SharedAccessKey &&
+		d.ClientIDOverride == other.ClientIDOverride &&
+		d.ModelID == other.ModelID &&
+		d.TelemetryTopicTemplate == other.TelemetryTopicTemplate &&
+		qosEqual
+}
+
+// defaultQoS returns d.DefaultQoS, or 1 if it's nil. It returns an error if the QoS isn't supported by IoT Hub.
+func (d *Device) defaultQoS() (byte, error) {
+	if d.DefaultQoS == nil {
+		return 1, nil
+	}
+	if *d.DefaultQoS > 1 {
+		return 0, fmt.Errorf("iothub: unsupported default QoS %d; IoT Hub supports QoS 0 and 1", *d.DefaultQoS)
+	}
+	return *d.DefaultQoS, nil
 }
 
 // NewClient creates a github.com/eclipse/paho.mqtt.golang Client that may be used to connect to the device's Hub's MQTT broker using TLS,
@@ -58,6 +334,7 @@ type Device struct {
 //   - Client ID
 //   - Username
 //   - TLS configuration that supplies root CA certs and the device's cert
+//   - Password, if the device authenticates with a SharedAccessKey rather than a cert
 //   - Broker
 //
 // By passing in options you may customize the ClientOptions. Options are functions with this signature:
@@ -77,41 +354,94 @@ type Device struct {
 // Using option functions allows for sensible defaults — no options are required to establish a
 // connection — without loss of customizability.
 //
+// If the device authenticates with a SharedAccessKey, the client generates a new shared access signature each time
+// it connects. IoT Hub closes the connection when the signature it was opened with expires, so after 90% of the
+// signature's lifetime (or at the lead time given by WithSASRenewalLead) the client disconnects and connects again
+// with a new one, retrying for up to 5 minutes if the connect fails. The subscriptions made by this package's helpers
+// (ServeMethods, OnDesiredPropertiesChange, SubscribeAll, Events, and GetTwin) are restored once it's reconnected;
+// resubscribe to topics subscribed to directly with the client in an OnConnect handler, unless the session is
+// persistent.
+//
 // For more information about connecting to Azure IoT Hub's MQTT brokers see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#tlsssl-configuration.
 func (d *Device) NewClient(options ...func(*Device, *mqtt.ClientOptions) error) (mqtt.Client, error) {
-	// Load CA certs.
-	pemCerts, err := os.ReadFile(d.CACerts)
+	opts, err := d.BuildOptions(nil, options...)
 	if err != nil {
-		return nil, fmt.Errorf("iothub: failed to read CA certs: %v", err)
+		return nil, err
 	}
-	certpool := x509.NewCertPool()
-	if !certpool.AppendCertsFromPEM(pemCerts) {
-		return nil, fmt.Errorf("iothub: no certs were parsed from given CA certs")
+
+	return newMQTTClient(opts), nil
+}
+
+// NewClientForBroker is like NewClient but connects to the given broker instead of IoT Hub, e.g. a local Mosquitto or
+// IoT Edge hub for integration tests. The client uses the device's client ID, username, and topics as usual. If
+// tlsConf is nil the connection doesn't use TLS (a tcp:// URL), so the device's cert isn't loaded or presented;
+// otherwise tlsConf is used as is.
+func (d *Device) NewClientForBroker(broker MQTTBroker, tlsConf *tls.Config, options ...func(*Device, *mqtt.ClientOptions) error) (mqtt.Client, error) {
+	scheme := "tls"
+	if tlsConf == nil {
+		scheme = "tcp"
 	}
 
-	// Import client certificate/key pair
-	cert, err := tls.LoadX509KeyPair(d.CertPath, d.PrivKeyPath)
+	opts, err := d.clientOptions(broker.url(scheme), tlsConf, options...)
 	if err != nil {
-		return nil, fmt.Errorf("iothub: failed to load x509 key pair: %w", err)
+		return nil, err
 	}
 
-	tlsConf := &tls.Config{
-		RootCAs:      certpool,
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		Certificates: []tls.Certificate{cert},
-		MinVersion:   tls.VersionTLS12,
+	return newMQTTClient(opts), nil
+}
+
+// BuildOptions does everything NewClient does except create the client: it returns the fully-resolved ClientOptions,
+// with the given options applied, without touching the network. It's useful for validating a device's configuration.
+//
+// If caCerts is non-nil the root CA certs are read from it as PEM instead of from the file at d.CACerts.
+func (d *Device) BuildOptions(caCerts io.Reader, options ...func(*Device, *mqtt.ClientOptions) error) (*mqtt.ClientOptions, error) {
+	var certpool *x509.CertPool
+	var err error
+	if caCerts != nil {
+		certpool, _, err = LoadCACerts(caCerts)
+	} else {
+		certpool, err = d.RootCAPool()
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	broker := d.Broker()
+	return d.buildOptions(certpool, options...)
+}
+
+// buildOptions is like BuildOptions but trusts the root CA certs in certpool.
+func (d *Device) buildOptions(certpool *x509.CertPool, options ...func(*Device, *mqtt.ClientOptions) error) (*mqtt.ClientOptions, error) {
+	tlsConf, err := d.newTLSConfig(certpool)
+	if err != nil {
+		return nil, err
+	}
 
 	// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#tlsssl-configuration
+	broker := d.Broker()
+	return d.clientOptions(broker.URL(), tlsConf, options...)
+}
+
+// clientOptions returns options for connecting to the broker at brokerURL with the given TLS config, which may be
+// nil, and the device's identity, with the given options applied.
+func (d *Device) clientOptions(brokerURL string, tlsConf *tls.Config, options ...func(*Device, *mqtt.ClientOptions) error) (*mqtt.ClientOptions, error) {
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(broker.URL())
+	opts.AddBroker(brokerURL)
 	// IoT Hub expects the device ID as the client ID.
 	// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#using-the-mqtt-protocol-directly-as-a-device
-	opts.SetClientID(d.DeviceID)
+	opts.SetClientID(d.ClientID())
 	opts.SetUsername(d.Username())
-	opts.SetTLSConfig(tlsConf)
+	if tlsConf != nil {
+		opts.SetTLSConfig(tlsConf)
+	}
+	var renewal *sasRenewal
+	if d.SharedAccessKey != "" {
+		if err := d.setSASCredentials(opts, DefaultSASTokenTTL); err != nil {
+			return nil, err
+		}
+		renewal = &sasRenewal{d: d}
+		pendingSASRenewals.add(opts, renewal)
+		defer pendingSASRenewals.remove(opts)
+	}
 
 	for _, option := range options {
 		if err := option(d, opts); err != nil {
@@ -119,20 +449,311 @@ func (d *Device) NewClient(options ...func(*Device, *mqtt.ClientOptions) error)
 		}
 	}
 
-	return mqtt.NewClient(opts), nil
+	if renewal != nil {
+		if err := d.setSASRenewal(opts, renewal); err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+// TLSRequirements describes the TLS parameters that this package uses to connect to IoT Hub.
+type TLSRequirements struct {
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS12.
+	MinVersion uint16
+	// ClientAuth is the client authentication mode for devices that authenticate with an X.509 cert.
+	ClientAuth tls.ClientAuthType
+	// ServerRootCAs are the common names of the root CAs to which IoT Hub's server cert chains. The CA certs file
+	// given in Device.CACerts must include them.
+	ServerRootCAs []string
+}
+
+// IoTHubTLSRequirements returns the TLS parameters that NewTLSConfig enforces, for compliance documentation and
+// pre-flight checks.
+// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-tls-support.
+func IoTHubTLSRequirements() TLSRequirements {
+	return TLSRequirements{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ServerRootCAs: []string{
+			"DigiCert Global Root G2",
+			"Microsoft RSA Root Certificate Authority 2017",
+		},
+	}
+}
+
+// NewTLSConfig returns the TLS config with which the device connects to IoT Hub. It trusts the root CA certs in
+// d.CACerts. If the device has a cert and private key, they're used for client authentication; otherwise the config
+// has no client cert.
+func (d *Device) NewTLSConfig() (*tls.Config, error) {
+	certpool, err := d.RootCAPool()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.newTLSConfig(certpool)
+}
+
+// newTLSConfig is like NewTLSConfig but trusts the root CA certs in certpool.
+func (d *Device) newTLSConfig(certpool *x509.CertPool) (*tls.Config, error) {
+	req := IoTHubTLSRequirements()
+	tlsConf := &tls.Config{
+		RootCAs:    certpool,
+		MinVersion: req.MinVersion,
+	}
+
+	if d.hasClientCert() {
+		// Import client certificate/key pair
+		cert, err := d.loadKeyPair()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrKeyPairLoad, err)
+		}
+
+		tlsConf.ClientAuth = req.ClientAuth
+		tlsConf.Certificates = []tls.Certificate{cert}
+
+		// Supply the cert through a callback, which takes precedence over Certificates, so that handshakes for
+		// reconnects use the cert most recently loaded by ReloadCredentials.
+		if d.creds == nil {
+			d.creds = &credentials{}
+		}
+		d.creds.set(&cert)
+		tlsConf.GetClientCertificate = d.creds.get
+	}
+
+	return tlsConf, nil
+}
+
+// hasClientCert reports whether the device authenticates with a client cert, from any of the sources that
+// validateCredentials allows.
+func (d *Device) hasClientCert() bool {
+	return d.CertPath != "" || d.PrivKeyPath != "" || d.CombinedPEMPath != "" ||
+		d.CertPEM != "" || d.KeyPEM != "" || d.ClientCert != nil
+}
+
+// loadKeyPair loads the device's cert and private key, decrypting the key with d.KeyPassphrase if it's set. RSA and
+// EC keys are supported.
+func (d *Device) loadKeyPair() (tls.Certificate, error) {
+	switch {
+	case d.ClientCert != nil:
+		return *d.ClientCert, nil
+	case d.CertPEM != "" || d.KeyPEM != "":
+		return d.loadKeyPairPEM([]byte(d.CertPEM), []byte(d.KeyPEM))
+	case d.CombinedPEMPath != "":
+		return d.loadKeyPairFiles(d.CombinedPEMPath, d.CombinedPEMPath)
+	}
+	return d.loadKeyPairFiles(d.CertPath, d.PrivKeyPath)
+}
+
+// loadKeyPairFiles is like loadKeyPair but loads the cert and private key from the given files, which may be the
+// same file. Blocks other than certs are ignored in the cert file, and blocks other than private keys are ignored in
+// the key file.
+func (d *Device) loadKeyPairFiles(certPath, keyPath string) (tls.Certificate, error) {
+	if d.KeyPassphrase == "" {
+		return tls.LoadX509KeyPair(certPath, keyPath)
+	}
+
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return d.loadKeyPairPEM(certPEM, keyPEM)
+}
+
+// loadKeyPairPEM is like loadKeyPairFiles but takes the PEM-encoded cert and private key themselves.
+func (d *Device) loadKeyPairPEM(certPEM, keyPEM []byte) (tls.Certificate, error) {
+	if d.KeyPassphrase == "" {
+		return tls.X509KeyPair(certPEM, keyPEM)
+	}
+
+	var block *pem.Block
+	for rest := keyPEM; ; {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return tls.Certificate{}, fmt.Errorf("failed to decode PEM private key")
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			break
+		}
+	}
+
+	//lint:ignore SA1019 Legacy PEM encryption is insecure, but it's what some devices' tooling produces.
+	if x509.IsEncryptedPEMBlock(block) {
+		//lint:ignore SA1019 See above.
+		der, err := x509.DecryptPEMBlock(block, []byte(d.KeyPassphrase))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to decrypt private key: %w", err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// RootCAPool returns a pool of the root CA certs that the device trusts, read from the file at d.CACerts. Because
+// the pool is built per device, devices that connect through different gateways can trust different CAs.
+func (d *Device) RootCAPool() (*x509.CertPool, error) {
+	pemCerts, err := os.ReadFile(d.CACerts)
+	if err != nil {
+		return nil, fmt.Errorf("iothub: failed to read CA certs: %w", err)
+	}
+
+	certpool := x509.NewCertPool()
+	if appendCerts(certpool, pemCerts) == 0 {
+		return nil, fmt.Errorf("%w from %s", ErrNoCACerts, d.CACerts)
+	}
+
+	return certpool, nil
+}
+
+// LoadCACerts returns a pool of the PEM-encoded certs read from r and the number of certs added to it, e.g. so that
+// the caller can log how many roots were loaded. PEM blocks that aren't certs, certs that can't be parsed, and data
+// outside of PEM blocks are skipped, so one bad cert in a bundle doesn't keep the rest from loading. It returns
+// ErrNoCACerts if no certs were added.
+func LoadCACerts(r io.Reader) (*x509.CertPool, int, error) {
+	pemCerts, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("iothub: failed to read CA certs: %w", err)
+	}
+
+	certpool := x509.NewCertPool()
+	n := appendCerts(certpool, pemCerts)
+	if n == 0 {
+		return nil, 0, ErrNoCACerts
+	}
+
+	return certpool, n, nil
+}
+
+// appendCerts adds the certs in pemCerts to certpool as described by LoadCACerts and returns the number added.
+func appendCerts(certpool *x509.CertPool, pemCerts []byte) int {
+	n := 0
+	for rest := pemCerts; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return n
+		}
+		if block.Type != "CERTIFICATE" || len(block.Headers) != 0 {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certpool.AddCert(cert)
+		n++
+	}
+}
+
+// LoadCACertsDir returns a pool of the certs in the .pem and .crt files in dir, which is how many OS trust stores
+// (e.g. /etc/ssl/certs) are laid out. Other files, subdirectories, and files that contain no certs are skipped. It
+// returns an error if no certs were found.
+func LoadCACertsDir(dir string) (*x509.CertPool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("iothub: failed to read CA certs dir: %w", err)
+	}
+
+	certpool := x509.NewCertPool()
+	found := false
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".pem" && ext != ".crt") {
+			continue
+		}
+
+		pemCerts, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("iothub: failed to read CA certs: %w", err)
+		}
+		if appendCerts(certpool, pemCerts) > 0 {
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w from dir %s", ErrNoCACerts, dir)
+	}
+
+	return certpool, nil
 }
 
+// Broker returns the MQTT broker to which NewClient connects: the IoT Edge gateway if GatewayHostName is set,
+// otherwise the hub.
 func (d *Device) Broker() MQTTBroker {
+	host := d.hubHost()
+	if d.GatewayHostName != "" {
+		host = d.GatewayHostName
+	}
+
 	return MQTTBroker{
-		Host: fmt.Sprintf("%s.%s", d.HubName, azureDevicesEndpoint),
+		Host: host,
 		Port: 8883,
 	}
 }
 
+// hubHost returns the hub's host name, {hub name}.azure-devices.net, which identifies the hub even when the device
+// connects through a gateway.
+func (d *Device) hubHost() string {
+	return fmt.Sprintf("%s.%s", d.HubName, azureDevicesEndpoint)
+}
+
+// BrokerAddress returns the host and port to which NewClient connects, e.g. for allow-listing in a firewall: the
+// gateway's host if GatewayHostName is set, otherwise the hub's. Clients connect with MQTT over TLS on port 8883;
+// MQTT over WebSockets on port 443 isn't supported.
+func (d *Device) BrokerAddress() (host string, port int) {
+	b := d.Broker()
+	return b.Host, b.Port
+}
+
+// BrokerFQDN returns the fully qualified domain name of the broker to which NewClient connects, e.g.
+// myhub.azure-devices.net, or GatewayHostName if it's set.
+func (d *Device) BrokerFQDN() string {
+	return d.Broker().Host
+}
+
+// ResourceURI returns the URI of the device's identity in the hub, {hub name}.azure-devices.net/devices/{device ID},
+// with /modules/{module ID} appended if ModuleID is set. It's the resource to which a shared access signature for the
+// device or module is scoped; when signing, it's URL-encoded.
+// See https://learn.microsoft.com/en-us/azure/iot-hub/authenticate-authorize-sas.
+func (d *Device) ResourceURI() string {
+	uri := d.hubHost() + "/devices/" + d.DeviceID
+	if d.ModuleID != "" {
+		uri += "/modules/" + d.ModuleID
+	}
+	return uri
+}
+
 func (d *Device) ID() string {
 	return d.DeviceID
 }
 
+// ClientID returns the MQTT client ID used by NewClient: ClientIDOverride if it's set, otherwise DeviceID, or
+// {device ID}/{module ID} if ModuleID is set.
+func (d *Device) ClientID() string {
+	if d.ClientIDOverride != "" {
+		return d.ClientIDOverride
+	}
+	return d.identity()
+}
+
+// identity returns the identity with which the client authenticates: the device ID, or {device ID}/{module ID} for a
+// module.
+func (d *Device) identity() string {
+	if d.ModuleID != "" {
+		return d.DeviceID + "/" + d.ModuleID
+	}
+	return d.DeviceID
+}
+
 // Username returns a username formatted as required by IoT Hub.
 func (d *Device) Username() string {
 	// The IoT Hub documentation recommends including an API version in the username, like this:
@@ -141,17 +762,163 @@ func (d *Device) Username() string {
 	// recommended version is different depending on where you look in the docs) results in failure to connect. I get
 	// "Connection Refused: Server Unavailable" when it's included. Therefore an API version is not included here.
 	// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#using-the-mqtt-protocol-directly-as-a-device.
-	return fmt.Sprintf("%s.%s/%s", d.HubName, azureDevicesEndpoint, d.DeviceID)
+	//
+	// Advertising a Plug and Play model ID requires an API version, though, so one is included when d.ModelID is set.
+	// See https://learn.microsoft.com/en-us/azure/iot-develop/concepts-developer-guide-device#model-id-announcement.
+	username := fmt.Sprintf("%s/%s", d.hubHost(), d.identity())
+	if d.ModelID != "" {
+		username += fmt.Sprintf("/?api-version=%s&model-id=%s", pnpAPIVersion, url.QueryEscape(d.ModelID))
+	}
+	return username
 }
 
 // CommandTopic returns the MQTT topic to which the device can subscribe to get commands.
 // For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#receiving-cloud-to-device-messages.
 func (d *Device) CommandTopic() string {
-	return fmt.Sprintf("devices/%v/messages/devicebound/#", d.DeviceID)
+	return devicesPrefix + d.DeviceID + commandSuffix + multiLevelPattern
 }
 
-// TelemetryTopic returns the MQTT topic to which the device should publish telemetry events.
+// CommandTopicFilter returns a topic filter for a narrower set of cloud-to-device messages than CommandTopic, namely
+// devices/{device ID}/messages/devicebound/{subpath}, for backends that send to known subpaths. Leading, trailing,
+// and repeated slashes in subpath are removed as in TelemetrySubTopic. subpath may use the MQTT wildcards, + as a
+// whole level and # as the whole last level. If subpath is empty the filter is CommandTopic.
+func (d *Device) CommandTopicFilter(subpath string) (string, error) {
+	var segments []string
+	for _, s := range strings.Split(subpath, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	if len(segments) == 0 {
+		return d.CommandTopic(), nil
+	}
+
+	for i, s := range segments {
+		switch {
+		case strings.ContainsRune(s, 0):
+			return "", fmt.Errorf("iothub: invalid command subpath %q: contains NUL", subpath)
+		case s == multiLevelPattern && i != len(segments)-1:
+			return "", fmt.Errorf("iothub: invalid command subpath %q: %s must be the last level", subpath, multiLevelPattern)
+		case s != multiLevelPattern && s != "+" && strings.ContainsAny(s, "+#"):
+			return "", fmt.Errorf("iothub: invalid command subpath %q: wildcards must be a whole level", subpath)
+		}
+	}
+	return devicesPrefix + d.DeviceID + commandSuffix + strings.Join(segments, "/"), nil
+}
+
+// TelemetryTopic returns the MQTT topic to which the device should publish telemetry events. If ModuleID is set it's
+// the module's telemetry topic, devices/{device ID}/modules/{module ID}/messages/events/. If TelemetryTopicTemplate is
+// set it's the template with its placeholders filled in and, so that a property bag can be appended, a trailing slash
+// added if it doesn't have one.
 // For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#sending-device-to-cloud-messages.
 func (d *Device) TelemetryTopic() string {
-	return fmt.Sprintf("devices/%v/messages/events/", d.DeviceID)
+	if d.TelemetryTopicTemplate != "" {
+		topic := strings.NewReplacer("{deviceID}", d.DeviceID, "{moduleID}", d.ModuleID).Replace(d.TelemetryTopicTemplate)
+		if !strings.HasSuffix(topic, "/") {
+			topic += "/"
+		}
+		return topic
+	}
+	if d.ModuleID != "" {
+		return devicesPrefix + d.DeviceID + modulesInfix + d.ModuleID + telemetrySuffix
+	}
+	return devicesPrefix + d.DeviceID + telemetrySuffix
+}
+
+// TelemetryTopicWithProperties returns the MQTT topic to which the device should publish a telemetry event that has
+// the given properties. The properties are encoded as a property bag at the end of the telemetry topic.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#sending-device-to-cloud-messages.
+func (d *Device) TelemetryTopicWithProperties(props TelemetryProperties) string {
+	return d.TelemetryTopic() + props.Encode()
+}
+
+// TelemetrySubTopic returns a topic under the device's telemetry topic, for routing by topic. Leading, trailing, and
+// repeated slashes in subpath are removed, so "/alerts//high/" and "alerts/high" give the same topic,
+// devices/{device ID}/messages/events/alerts/high/. If subpath is empty the telemetry topic itself is returned.
+func (d *Device) TelemetrySubTopic(subpath string) string {
+	var segments []string
+	for _, s := range strings.Split(subpath, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	if len(segments) == 0 {
+		return d.TelemetryTopic()
+	}
+	return d.TelemetryTopic() + strings.Join(segments, "/") + "/"
+}
+
+// TelemetrySubTopicWithProperties is like TelemetrySubTopic but with the given properties encoded as a property bag
+// at the end of the topic, as with TelemetryTopicWithProperties.
+func (d *Device) TelemetrySubTopicWithProperties(subpath string, props TelemetryProperties) string {
+	return d.TelemetrySubTopic(subpath) + props.Encode()
+}
+
+// TwinResponseTopic returns the MQTT topic to which the device should subscribe to get responses to device twin
+// requests. For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#retrieving-a-device-twins-properties.
+func (d *Device) TwinResponseTopic() string {
+	return TwinResponsePrefix + multiLevelPattern
+}
+
+// TwinGetTopic returns the MQTT topic to which the device should publish to request its device twin. The request ID
+// is echoed back in the response so that the response can be matched to the request.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#retrieving-a-device-twins-properties.
+func (d *Device) TwinGetTopic(requestID string) string {
+	return TwinGetPrefix + requestIDQuery + requestID
+}
+
+// TwinReportedPropertiesTopic returns the MQTT topic to which the device should publish to update its reported
+// properties. The request ID is echoed back in the response so that the response can be matched to the request.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#update-device-twins-reported-properties.
+func (d *Device) TwinReportedPropertiesTopic(requestID string) string {
+	return TwinReportedPropertiesPrefix + requestIDQuery + requestID
+}
+
+// DesiredPropertiesTopic returns the MQTT topic to which the device should subscribe to be notified of updates to its
+// twin's desired properties.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#receiving-desired-properties-update-notifications.
+func (d *Device) DesiredPropertiesTopic() string {
+	return DesiredPropertiesPrefix + multiLevelPattern
+}
+
+// MethodRequestTopic returns the MQTT topic to which the device should subscribe to receive direct method requests.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#respond-to-a-direct-method.
+func (d *Device) MethodRequestTopic() string {
+	return MethodRequestPrefix + multiLevelPattern
+}
+
+// MethodResponseTopic returns the MQTT topic to which the device should publish its response to the direct method
+// request with the given request ID.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#respond-to-a-direct-method.
+func (d *Device) MethodResponseTopic(status int, requestID string) string {
+	return MethodResponsePrefix + strconv.Itoa(status) + "/" + requestIDQuery + requestID
+}
+
+// DeviceTopics holds all of the MQTT topics used by a device. Topics that include a request ID have the placeholder
+// "{rid}" in its place.
+type DeviceTopics struct {
+	Telemetry          string
+	Command            string
+	TwinResponse       string
+	TwinGet            string
+	TwinReportedUpdate string
+	DesiredUpdates     string
+	MethodRequest      string
+}
+
+// Topics returns all of the MQTT topics used by the device. It's meant for logging and debugging; use the individual
+// topic methods to get topics to publish or subscribe to.
+func (d *Device) Topics() DeviceTopics {
+	const rid = "{rid}"
+	return DeviceTopics{
+		Telemetry:          d.TelemetryTopic(),
+		Command:            d.CommandTopic(),
+		TwinResponse:       d.TwinResponseTopic(),
+		TwinGet:            d.TwinGetTopic(rid),
+		TwinReportedUpdate: d.TwinReportedPropertiesTopic(rid),
+		DesiredUpdates:     d.DesiredPropertiesTopic(),
+		MethodRequest:      d.MethodRequestTopic(),
+	}
 }
@@ -0,0 +1,76 @@
+// Package iothubtest provides utilities for testing code that uses package iothub.
+package iothubtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mtraver/iothub"
+)
+
+// HubName is the hub name of the devices returned by TestDevice.
+const HubName = "iothubtest"
+
+// TestDevice returns a valid device with the given ID whose cert and private key are freshly generated: the cert is
+// self-signed, with the device ID as its Common Name, and it's also the device's CA cert. The files are written to a
+// temporary directory that's removed when the test and its subtests complete.
+//
+// The device can be used to construct clients, e.g. with NewClient, but not to connect to IoT Hub.
+func TestDevice(tb testing.TB, deviceID string) iothub.Device {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatalf("iothubtest: failed to generate key: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		tb.Fatalf("iothubtest: failed to marshal key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: deviceID},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		tb.Fatalf("iothubtest: failed to create cert: %v", err)
+	}
+
+	dir := tb.TempDir()
+	certPath := writeFile(tb, dir, "cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))
+	keyPath := writeFile(tb, dir, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+
+	return iothub.Device{
+		HubName:     HubName,
+		DeviceID:    deviceID,
+		CACerts:     certPath,
+		CertPath:    certPath,
+		PrivKeyPath: keyPath,
+	}
+}
+
+func writeFile(tb testing.TB, dir, name string, contents []byte) string {
+	tb.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, contents, 0600); err != nil {
+		tb.Fatalf("iothubtest: failed to write %s: %v", path, err)
+	}
+	return path
+}
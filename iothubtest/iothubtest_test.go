@@ -0,0 +1,26 @@
+package iothubtest
+
+import (
+	"testing"
+
+	"github.com/mtraver/iothub"
+)
+
+func TestTestDevice(t *testing.T) {
+	d := TestDevice(t, "foo")
+	if err := d.Validate(); err != nil {
+		t.Fatalf("device isn't valid: %v", err)
+	}
+
+	id, err := iothub.DeviceIDFromCert(d.CertPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != "foo" {
+		t.Errorf("got device ID %q from cert, want %q", id, "foo")
+	}
+
+	if _, err := d.NewClient(); err != nil {
+		t.Errorf("NewClient failed: %v", err)
+	}
+}
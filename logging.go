@@ -0,0 +1,40 @@
+package iothub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// slogLogger is an mqtt.Logger that logs to a slog.Logger at a fixed level.
+type slogLogger struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+func (l slogLogger) Println(v ...interface{}) {
+	l.log(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+func (l slogLogger) Printf(format string, v ...interface{}) {
+	l.log(fmt.Sprintf(format, v...))
+}
+
+func (l slogLogger) log(msg string) {
+	l.logger.Log(context.Background(), l.level, msg, slog.String("component", "mqtt"))
+}
+
+// EnablePahoLogging routes the log output of github.com/eclipse/paho.mqtt.golang, which is discarded by default, to
+// logger. paho's DEBUG, WARN, and ERROR loggers log at the corresponding slog levels; its CRITICAL logger also logs at
+// slog.LevelError. Each record has a "component" attribute with the value "mqtt".
+//
+// paho's loggers are package-level, so this affects all clients in the process.
+func EnablePahoLogging(logger *slog.Logger) {
+	mqtt.DEBUG = slogLogger{logger: logger, level: slog.LevelDebug}
+	mqtt.WARN = slogLogger{logger: logger, level: slog.LevelWarn}
+	mqtt.ERROR = slogLogger{logger: logger, level: slog.LevelError}
+	mqtt.CRITICAL = slogLogger{logger: logger, level: slog.LevelError}
+}
@@ -0,0 +1,72 @@
+package iothub
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// recordingHandler is a slog.Handler that records the records it handles.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordingHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func TestEnablePahoLogging(t *testing.T) {
+	origDebug, origWarn, origError, origCritical := mqtt.DEBUG, mqtt.WARN, mqtt.ERROR, mqtt.CRITICAL
+	t.Cleanup(func() {
+		mqtt.DEBUG, mqtt.WARN, mqtt.ERROR, mqtt.CRITICAL = origDebug, origWarn, origError, origCritical
+	})
+
+	h := &recordingHandler{}
+	EnablePahoLogging(slog.New(h))
+
+	mqtt.DEBUG.Println("[client]", "connecting")
+	mqtt.WARN.Printf("[%s] %s", "net", "slow")
+	mqtt.ERROR.Println("[client]", "failed")
+	mqtt.CRITICAL.Printf("[%s] %s", "pinger", "timeout")
+
+	want := []struct {
+		level slog.Level
+		msg   string
+	}{
+		{slog.LevelDebug, "[client] connecting"},
+		{slog.LevelWarn, "[net] slow"},
+		{slog.LevelError, "[client] failed"},
+		{slog.LevelError, "[pinger] timeout"},
+	}
+	if len(h.records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(h.records), len(want))
+	}
+	for i, w := range want {
+		r := h.records[i]
+		if r.Level != w.level || r.Message != w.msg {
+			t.Errorf("record %d: got (%v, %q), want (%v, %q)", i, r.Level, r.Message, w.level, w.msg)
+		}
+
+		var component string
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == "component" {
+				component = a.Value.String()
+			}
+			return true
+		})
+		if component != "mqtt" {
+			t.Errorf("record %d: got component %q, want %q", i, component, "mqtt")
+		}
+	}
+}
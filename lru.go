@@ -0,0 +1,42 @@
+package iothub
+
+import (
+	"container/list"
+	"sync"
+)
+
+// recentIDs remembers the most recently seen IDs, up to a fixed number, evicting the least recently seen first.
+type recentIDs struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // Front is most recent.
+	elems map[string]*list.Element
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{
+		size:  size,
+		order: list.New(),
+		elems: make(map[string]*list.Element),
+	}
+}
+
+// seen reports whether id has been seen recently, and records that it has been seen now.
+func (r *recentIDs) seen(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.elems[id]; ok {
+		r.order.MoveToFront(e)
+		return true
+	}
+
+	r.elems[id] = r.order.PushFront(id)
+	if r.order.Len() > r.size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.elems, oldest.Value.(string))
+	}
+
+	return false
+}
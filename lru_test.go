@@ -0,0 +1,29 @@
+package iothub
+
+import (
+	"testing"
+)
+
+func TestRecentIDs(t *testing.T) {
+	r := newRecentIDs(2)
+
+	steps := []struct {
+		id   string
+		want bool
+	}{
+		{"a", false},
+		{"a", true},
+		{"b", false},
+		{"a", true},
+		// Evicts b, the least recently seen.
+		{"c", false},
+		{"a", true},
+		{"b", false},
+	}
+
+	for i, s := range steps {
+		if got := r.seen(s.id); got != s.want {
+			t.Errorf("step %d: seen(%q) = %v, want %v", i, s.id, got, s.want)
+		}
+	}
+}
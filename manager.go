@@ -0,0 +1,139 @@
+package iothub
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// managerQuiesce is how long DeviceManager.DisconnectAll waits for each client's in-flight work to complete.
+const managerQuiesce = 250 * time.Millisecond
+
+// DeviceManager manages the MQTT clients of many devices, e.g. the leaf devices behind a gateway. Each device gets its
+// own client, but devices that trust the same CA certs file share a root CA pool rather than each reading and parsing
+// the file.
+//
+// A DeviceManager is safe for concurrent use.
+type DeviceManager struct {
+	options []func(*Device, *mqtt.ClientOptions) error
+
+	mu      sync.Mutex
+	pools   map[string]*x509.CertPool // Keyed by CA certs path.
+	devices map[string]*Device        // Keyed by identity: device ID, or {device ID}/{module ID}.
+	clients map[string]mqtt.Client    // Keyed by identity.
+}
+
+// NewDeviceManager returns a DeviceManager that creates clients with the given options. See Device.NewClient.
+func NewDeviceManager(options ...func(*Device, *mqtt.ClientOptions) error) *DeviceManager {
+	return &DeviceManager{
+		options: options,
+		pools:   make(map[string]*x509.CertPool),
+		devices: make(map[string]*Device),
+		clients: make(map[string]mqtt.Client),
+	}
+}
+
+// Add validates the device and creates its client. It doesn't connect the client. It returns an error if a device
+// with the same ID, or a module with the same device and module IDs, has already been added. Modules of the same
+// device, and the device itself, may all be added.
+func (m *DeviceManager) Add(d Device) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := d.identity()
+	if _, ok := m.devices[id]; ok {
+		return fmt.Errorf("iothub: device %q has already been added", id)
+	}
+
+	pool, ok := m.pools[d.CACerts]
+	if !ok {
+		var err error
+		if pool, err = d.RootCAPool(); err != nil {
+			return err
+		}
+		m.pools[d.CACerts] = pool
+	}
+
+	opts, err := d.buildOptions(pool, m.options...)
+	if err != nil {
+		return err
+	}
+
+	m.devices[id] = &d
+	m.clients[id] = newMQTTClient(opts)
+	return nil
+}
+
+// Client returns the client of the device with the given ID. For a module, id is {device ID}/{module ID}.
+func (m *DeviceManager) Client(id string) (mqtt.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	client, ok := m.clients[id]
+	if !ok {
+		return nil, fmt.Errorf("iothub: no device with ID %q", id)
+	}
+	return client, nil
+}
+
+// ConnectAll connects the clients of all the devices concurrently and waits for them to connect or for the context
+// to be done. Clients that are already connected are left alone. The returned error joins the errors of all clients
+// that failed to connect.
+func (m *DeviceManager) ConnectAll(ctx context.Context) error {
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.clients))
+	clients := make([]mqtt.Client, 0, len(m.clients))
+	for id := range m.clients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		clients = append(clients, m.clients[id])
+	}
+	m.mu.Unlock()
+
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		if client.IsConnected() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, client mqtt.Client) {
+			defer wg.Done()
+			if err := connectError(waitToken(ctx, client.Connect())); err != nil {
+				errs[i] = fmt.Errorf("iothub: failed to connect device %q: %w", ids[i], err)
+			}
+		}(i, client)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// DisconnectAll disconnects the clients of all the devices as described by Device.Disconnect.
+func (m *DeviceManager) DisconnectAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for id, client := range m.clients {
+		wg.Add(1)
+		go func(d *Device, client mqtt.Client) {
+			defer wg.Done()
+			d.Disconnect(client, managerQuiesce)
+		}(m.devices[id], client)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,141 @@
+package iothub
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeNewMQTTClient makes NewClient and DeviceManager create fakeClients for the duration of the test. It returns a
+// map from client ID to the options each client was created with.
+func fakeNewMQTTClient(t *testing.T) map[string]*mqtt.ClientOptions {
+	t.Helper()
+
+	orig := newMQTTClient
+	t.Cleanup(func() { newMQTTClient = orig })
+
+	created := make(map[string]*mqtt.ClientOptions)
+	newMQTTClient = func(opts *mqtt.ClientOptions) mqtt.Client {
+		created[opts.ClientID] = opts
+		return &fakeClient{}
+	}
+	return created
+}
+
+func TestDeviceManager(t *testing.T) {
+	created := fakeNewMQTTClient(t)
+
+	a := newTestDevice(t)
+	b := a
+	b.DeviceID = "bar"
+
+	m := NewDeviceManager()
+	if err := m.Add(a); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.Add(b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clientA, err := m.Client("foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clientB, err := m.Client("bar")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if clientA == clientB {
+		t.Error("devices share a client")
+	}
+
+	if created["foo"].TLSConfig.RootCAs != created["bar"].TLSConfig.RootCAs {
+		t.Error("devices with the same CA certs don't share a root CA pool")
+	}
+
+	if err := m.ConnectAll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !clientA.IsConnected() || !clientB.IsConnected() {
+		t.Error("not all clients are connected")
+	}
+
+	m.DisconnectAll()
+	if clientA.IsConnected() || clientB.IsConnected() {
+		t.Error("not all clients are disconnected")
+	}
+}
+
+func TestDeviceManagerModules(t *testing.T) {
+	created := fakeNewMQTTClient(t)
+
+	d := newTestDevice(t)
+	a, b := d, d
+	a.ModuleID = "sensor"
+	b.ModuleID = "camera"
+
+	m := NewDeviceManager()
+	for _, dev := range []Device{d, a, b} {
+		if err := m.Add(dev); err != nil {
+			t.Fatalf("Add %s: unexpected error: %v", dev.ClientID(), err)
+		}
+	}
+	if err := m.Add(a); err == nil {
+		t.Error("expected error adding a module twice")
+	}
+
+	clients := make(map[mqtt.Client]bool)
+	for _, id := range []string{"foo", "foo/sensor", "foo/camera"} {
+		client, err := m.Client(id)
+		if err != nil {
+			t.Fatalf("Client(%q): unexpected error: %v", id, err)
+		}
+		clients[client] = true
+		if _, ok := created[id]; !ok {
+			t.Errorf("no client created with client ID %q", id)
+		}
+	}
+	if len(clients) != 3 {
+		t.Errorf("got %d distinct clients, want 3", len(clients))
+	}
+}
+
+func TestDeviceManagerErrors(t *testing.T) {
+	fakeNewMQTTClient(t)
+
+	d := newTestDevice(t)
+	m := NewDeviceManager()
+	if err := m.Add(d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := m.Add(d); err == nil {
+		t.Error("expected error adding a device twice")
+	}
+	if err := m.Add(Device{DeviceID: "invalid"}); err == nil {
+		t.Error("expected error adding an invalid device")
+	}
+	if _, err := m.Client("nope"); err == nil {
+		t.Error("expected error getting the client of a device that wasn't added")
+	}
+}
+
+func TestDeviceManagerConnectAllError(t *testing.T) {
+	errNetwork := errors.New("network is unreachable")
+	orig := newMQTTClient
+	t.Cleanup(func() { newMQTTClient = orig })
+	newMQTTClient = func(opts *mqtt.ClientOptions) mqtt.Client {
+		return &fakeClient{connectErrs: []error{errNetwork}}
+	}
+
+	m := NewDeviceManager()
+	if err := m.Add(newTestDevice(t)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := m.ConnectAll(context.Background()); !errors.Is(err, errNetwork) {
+		t.Errorf("got error %v, want %v", err, errNetwork)
+	}
+}
@@ -0,0 +1,30 @@
+package iothub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// LoadDevices decodes a fleet manifest, a JSON array of devices in the format that Device marshals to, and validates
+// each device as Validate does. If any device is invalid it returns an error naming each invalid entry by index and
+// device ID, and no devices.
+func LoadDevices(r io.Reader) ([]Device, error) {
+	var devices []Device
+	if err := json.NewDecoder(r).Decode(&devices); err != nil {
+		return nil, fmt.Errorf("iothub: failed to decode device manifest: %w", err)
+	}
+
+	var errs []error
+	for i := range devices {
+		if err := devices[i].Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("iothub: device %d (%q) is invalid: %w", i, devices[i].DeviceID, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
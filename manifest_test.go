@@ -0,0 +1,45 @@
+package iothub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadDevices(t *testing.T) {
+	manifest := `[
+		{"hub_name": "myhub", "device_id": "foo", "ca_certs_path": "roots.pem", "cert_path": "foo.x509", "priv_key_path": "foo.pem"},
+		{"hub_name": "myhub", "device_id": "bar", "ca_certs_path": "roots.pem", "cert_path": "bar.x509", "priv_key_path": "bar.pem"}
+	]`
+
+	devices, err := LoadDevices(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 2 || devices[0].DeviceID != "foo" || devices[1].DeviceID != "bar" {
+		t.Errorf("got %v, want devices foo and bar", devices)
+	}
+}
+
+func TestLoadDevicesInvalid(t *testing.T) {
+	manifest := `[
+		{"hub_name": "myhub", "device_id": "foo", "ca_certs_path": "roots.pem", "cert_path": "foo.x509", "priv_key_path": "foo.pem"},
+		{"hub_name": "myhub", "device_id": "bar", "ca_certs_path": "roots.pem", "cert_path": "bar.x509"}
+	]`
+
+	devices, err := LoadDevices(strings.NewReader(manifest))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if devices != nil {
+		t.Errorf("got devices %v, want nil", devices)
+	}
+	if msg := err.Error(); !strings.Contains(msg, `device 1 ("bar")`) || strings.Contains(msg, `"foo"`) {
+		t.Errorf("got error %q, want one naming only device 1 (bar)", msg)
+	}
+}
+
+func TestLoadDevicesMalformed(t *testing.T) {
+	if _, err := LoadDevices(strings.NewReader(`{"hub_name": "myhub"}`)); err == nil {
+		t.Error("expected error for a manifest that isn't an array")
+	}
+}
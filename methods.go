@@ -0,0 +1,110 @@
+package iothub
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MethodRouter routes direct method requests to handlers by method name. The zero value is ready to use.
+type MethodRouter struct {
+	mu       sync.RWMutex
+	handlers map[string]func(payload []byte) (status int, response []byte)
+	recent   *recentIDs
+}
+
+// Deduplicate makes the router ignore a request if it has the same request ID as one of the n most recent requests.
+// This guards against handling a request twice when it's redelivered, which is possible because requests are
+// delivered with QoS 0 or 1. By default requests aren't deduplicated; n <= 0 turns deduplication off.
+func (r *MethodRouter) Deduplicate(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n <= 0 {
+		r.recent = nil
+		return
+	}
+	r.recent = newRecentIDs(n)
+}
+
+// isDuplicate reports whether the request ID has been seen recently, if the router is deduplicating requests.
+func (r *MethodRouter) isDuplicate(requestID string) bool {
+	r.mu.RLock()
+	recent := r.recent
+	r.mu.RUnlock()
+
+	return recent != nil && recent.seen(requestID)
+}
+
+// Handle registers the handler for the named method. The handler is given the request payload and returns the status
+// and payload of the response. If a handler is already registered for the method it is replaced.
+func (r *MethodRouter) Handle(methodName string, fn func(payload []byte) (status int, response []byte)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.handlers == nil {
+		r.handlers = make(map[string]func([]byte) (int, []byte))
+	}
+	r.handlers[methodName] = fn
+}
+
+// dispatch calls the handler registered for the named method. If there is no such handler the status is 404.
+func (r *MethodRouter) dispatch(methodName string, payload []byte) (int, []byte) {
+	r.mu.RLock()
+	fn, ok := r.handlers[methodName]
+	r.mu.RUnlock()
+
+	if !ok {
+		return 404, nil
+	}
+	return fn(payload)
+}
+
+// parseMethodRequestTopic parses a topic of the form $iothub/methods/POST/{method name}/?$rid={request ID}.
+func parseMethodRequestTopic(topic string) (string, string, error) {
+	if !strings.HasPrefix(topic, MethodRequestPrefix) {
+		return "", "", fmt.Errorf("iothub: not a method request topic: %q", topic)
+	}
+
+	name, _, ok := strings.Cut(strings.TrimPrefix(topic, MethodRequestPrefix), "/?")
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("iothub: malformed method request topic: %q", topic)
+	}
+
+	rid, err := ParseRequestID(topic)
+	if err != nil {
+		return "", "", err
+	}
+
+	return name, rid, nil
+}
+
+// ServeMethods subscribes to direct method requests and dispatches each one to the handler registered with the router
+// for the requested method. The handler's status and payload are published as the response. Requests for methods with
+// no registered handler get a response with status 404. See MethodRouter.Deduplicate for handling redelivered
+// requests.
+//
+// Handlers are called from the MQTT client's message handler, so the same restrictions apply: they must not block.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#respond-to-a-direct-method.
+func (d *Device) ServeMethods(client mqtt.Client, r *MethodRouter) error {
+	handler := func(client mqtt.Client, msg mqtt.Message) {
+		name, rid, err := parseMethodRequestTopic(msg.Topic())
+		if err != nil || r.isDuplicate(rid) {
+			return
+		}
+
+		status, response := r.dispatch(name, msg.Payload())
+		client.Publish(d.MethodResponseTopic(status, rid), 0, false, response)
+	}
+
+	token := client.Subscribe(d.MethodRequestTopic(), 0, handler)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("iothub: failed to subscribe to method requests: %w", err)
+	}
+	trackSubscription(client, 0, handler, d.MethodRequestTopic())
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package iothub
+
+import (
+	"testing"
+)
+
+func TestServeMethods(t *testing.T) {
+	var r MethodRouter
+	r.Handle("reboot", func(payload []byte) (int, []byte) {
+		return 200, append([]byte("rebooting "), payload...)
+	})
+
+	client := &fakeClient{}
+	if err := device.ServeMethods(client, &r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name        string
+		topic       string
+		wantTopic   string
+		wantPayload string
+	}{
+		{"registered", "$iothub/methods/POST/reboot/?$rid=1", "$iothub/methods/res/200/?$rid=1", "rebooting now"},
+		{"unknown", "$iothub/methods/POST/selfdestruct/?$rid=2", "$iothub/methods/res/404/?$rid=2", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client.published = nil
+			client.deliver(c.topic, []byte("now"))
+
+			if len(client.published) != 1 {
+				t.Fatalf("got %d published messages, want 1", len(client.published))
+			}
+			if got := client.published[0].topic; got != c.wantTopic {
+				t.Errorf("got topic %q, want %q", got, c.wantTopic)
+			}
+			if got := string(client.published[0].payload); got != c.wantPayload {
+				t.Errorf("got payload %q, want %q", got, c.wantPayload)
+			}
+		})
+	}
+}
+
+func TestServeMethodsDeduplicate(t *testing.T) {
+	calls := 0
+	var r MethodRouter
+	r.Handle("reboot", func(payload []byte) (int, []byte) {
+		calls++
+		return 200, nil
+	})
+	r.Deduplicate(10)
+
+	client := &fakeClient{}
+	if err := device.ServeMethods(client, &r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	client.deliver("$iothub/methods/POST/reboot/?$rid=1", nil)
+	client.deliver("$iothub/methods/POST/reboot/?$rid=1", nil)
+	client.deliver("$iothub/methods/POST/reboot/?$rid=2", nil)
+
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+	if len(client.published) != 2 {
+		t.Errorf("got %d published responses, want 2", len(client.published))
+	}
+}
+
+func TestParseMethodRequestTopic(t *testing.T) {
+	cases := []struct {
+		topic    string
+		wantName string
+		wantRID  string
+		wantErr  bool
+	}{
+		{"$iothub/methods/POST/reboot/?$rid=1", "reboot", "1", false},
+		{"$iothub/methods/POST//?$rid=1", "", "", true},
+		{"$iothub/methods/POST/reboot/", "", "", true},
+		{"$iothub/methods/POST/reboot/?foo=bar", "", "", true},
+		{"$iothub/twin/res/200/?$rid=1", "", "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.topic, func(t *testing.T) {
+			name, rid, err := parseMethodRequestTopic(c.topic)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, c.wantErr)
+			}
+			if name != c.wantName || rid != c.wantRID {
+				t.Errorf("got (%q, %q), want (%q, %q)", name, rid, c.wantName, c.wantRID)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package iothub
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"golang.org/x/net/proxy"
+)
+
+// WithProtocolVersion returns an option that sets the MQTT protocol version: 3 for MQTT 3.1 or 4 for MQTT 3.1.1.
+// Without it the client tries 3.1.1 and falls back to 3.1, which is paho's default.
+//
+// IoT Hub's device-facing MQTT support, including telemetry, cloud-to-device messages, device twins, and direct methods,
+// is built on MQTT 3.1.1. IoT Hub's MQTT 5 support is limited to a preview and isn't usable with this package because
+// github.com/eclipse/paho.mqtt.golang doesn't implement MQTT 5; any version other than 3 or 4 is an error.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support.
+func WithProtocolVersion(v uint) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		if v != 3 && v != 4 {
+			return fmt.Errorf("iothub: unsupported MQTT protocol version %d; must be 3 (MQTT 3.1) or 4 (MQTT 3.1.1)", v)
+		}
+
+		opts.SetProtocolVersion(v)
+		return nil
+	}
+}
+
+// ServerIdleTimeout is the longest IoT Hub lets a connection go without hearing from the client before it closes it.
+// IoT Hub closes a connection after 1.5 times the client's keep-alive interval, capped at ServerIdleTimeout.
+// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support.
+const ServerIdleTimeout = 1767 * time.Second
+
+// RecommendedKeepAlive returns a keep-alive interval for clients connecting to IoT Hub. It's the interval used by the
+// Azure IoT C SDK, 4 minutes, which is well under ServerIdleTimeout divided by 1.5 and short enough to keep idle
+// connections open through most NATs and firewalls.
+func RecommendedKeepAlive() time.Duration {
+	return 4 * time.Minute
+}
+
+// WithIoTHubDefaults returns an option that sets the client options that suit IoT Hub: MQTT 3.1.1 without falling
+// back to 3.1, which IoT Hub doesn't support, and a keep-alive interval of RecommendedKeepAlive. Give it before other
+// options so that they can override its settings.
+func WithIoTHubDefaults() func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		opts.SetProtocolVersion(4)
+		opts.SetKeepAlive(RecommendedKeepAlive())
+		return nil
+	}
+}
+
+// WithJitteredKeepAlive returns an option that sets a keep-alive interval chosen at random in [base-jitter,
+// base+jitter]. Across a fleet this spreads out the keep-alive pings, and the reconnects that follow when a hub
+// restarts, rather than having every device act in lockstep. paho uses whole seconds, so the interval is rounded down
+// to a second. base must be greater than jitter, and jitter must not be negative.
+func WithJitteredKeepAlive(base, jitter time.Duration) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		if jitter < 0 || base <= jitter {
+			return fmt.Errorf("iothub: keep-alive jitter must be in [0, base), got base %v and jitter %v", base, jitter)
+		}
+
+		opts.SetKeepAlive(base - jitter + time.Duration(rand.Int63n(int64(2*jitter)+1)))
+		return nil
+	}
+}
+
+// WithTimeouts returns an option that sets how long the client waits for a connection to be established and how long
+// it waits for a write to the connection (e.g. a publish) to complete before giving up. A write timeout of 0, paho's
+// default, means a publish waits up to 30 seconds to be queued and then indefinitely to be written; see
+// WithWriteTimeout.
+func WithTimeouts(connect, write time.Duration) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		opts.SetConnectTimeout(connect)
+		opts.SetWriteTimeout(write)
+		return nil
+	}
+}
+
+// WithWriteTimeout returns an option that sets how long a publish may wait to be written to the connection before it
+// fails with ErrWriteTimeout. Without it, paho waits 30 seconds to queue a publish and then indefinitely for the write
+// itself, so a publish on a half-open connection can stall. It's the same as WithTimeouts' write timeout.
+func WithWriteTimeout(timeout time.Duration) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		opts.SetWriteTimeout(timeout)
+		return nil
+	}
+}
+
+// WithMaxInflight returns an option that limits the number of stored messages the client publishes at once when
+// resuming a session after reconnecting. Limiting it keeps a backlog from saturating a constrained link. 0 means
+// no limit, which is paho's default.
+func WithMaxInflight(n int) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		if n < 0 {
+			return fmt.Errorf("iothub: max in-flight messages must not be negative, got %d", n)
+		}
+
+		opts.SetMaxResumePubInFlight(n)
+		return nil
+	}
+}
+
+// WithFileStore returns an option that makes the client persist in-flight QoS 1 messages to files in dir, so that
+// they survive a restart of the process and are delivered when the client reconnects.
+func WithFileStore(dir string) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		opts.SetStore(mqtt.NewFileStore(dir))
+		return nil
+	}
+}
+
+// WithMemoryStore returns an option that makes the client keep in-flight messages in memory, which is paho's default.
+func WithMemoryStore() func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		opts.SetStore(mqtt.NewMemoryStore())
+		return nil
+	}
+}
+
+// WithServerName returns an option that sets the server name used to verify the broker's cert and sent in the TLS
+// handshake for SNI. Use it when the broker is reached at an address other than the name in its cert, as is the case
+// with some gateways and proxies. By default the server name is the broker's host.
+func WithServerName(name string) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		if opts.TLSConfig == nil {
+			return fmt.Errorf("iothub: cannot set TLS server name; no TLS config")
+		}
+
+		opts.TLSConfig.ServerName = name
+		return nil
+	}
+}
+
+// WithTLSCipherSuites returns an option that restricts the TLS cipher suites the client offers to the given IDs, e.g.
+// for FIPS-constrained deployments. See the constants in crypto/tls for the IDs. The list only applies to TLS 1.2;
+// Go does not allow configuring TLS 1.3 cipher suites.
+func WithTLSCipherSuites(ids []uint16) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		if opts.TLSConfig == nil {
+			return fmt.Errorf("iothub: cannot set TLS cipher suites; no TLS config")
+		}
+
+		opts.TLSConfig.CipherSuites = ids
+		return nil
+	}
+}
+
+// WithTLSCurves returns an option that restricts the elliptic curves the client uses in the TLS handshake to the given
+// curves, in order of preference.
+func WithTLSCurves(curves []tls.CurveID) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		if opts.TLSConfig == nil {
+			return fmt.Errorf("iothub: cannot set TLS curves; no TLS config")
+		}
+
+		opts.TLSConfig.CurvePreferences = curves
+		return nil
+	}
+}
+
+// WithDefaultPublishHandler returns an option that sets the handler for messages that don't match any subscription's
+// handler, e.g. messages on topics subscribed to with a nil handler.
+func WithDefaultPublishHandler(h mqtt.MessageHandler) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		opts.SetDefaultPublishHandler(h)
+		return nil
+	}
+}
+
+// WithConcurrentHandlers returns an option that makes the client run message handlers concurrently, each in its own
+// goroutine, rather than one at a time in the order messages arrive, which is paho's default. It keeps a slow handler
+// from holding up the rest, e.g. a long-running direct method from delaying twin responses.
+//
+// Handlers must then be safe for concurrent use, and messages may be handled out of order. The handlers used by this
+// package's helpers, such as GetTwin and ServeMethods, are safe for concurrent use, but a MethodRouter's handlers may
+// run concurrently with each other.
+func WithConcurrentHandlers() func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		opts.SetOrderMatters(false)
+		return nil
+	}
+}
+
+// WithResumeSubs returns an option that makes the client resend subscribe and unsubscribe requests that were stored
+// but not acknowledged when it last disconnected. This only happens on Connect, not on automatic reconnects, and only
+// if clean session is disabled with opts.SetCleanSession(false); otherwise stored requests are discarded.
+//
+// Note that this isn't what keeps subscriptions alive across reconnects. With clean session disabled, IoT Hub keeps a
+// device's subscriptions in its session, so they survive reconnects on their own; with it enabled, which is paho's
+// default, resubscribe in an OnConnect handler.
+// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support.
+func WithResumeSubs() func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		opts.SetResumeSubs(true)
+		return nil
+	}
+}
+
+// WithDialer returns an option that makes the client open its connection to the broker with dialer, e.g. a SOCKS5
+// dialer from proxy.SOCKS5 for devices behind a proxy. The TLS handshake is made over the dialed connection with the
+// client's TLS config, unless the broker URL is plain MQTT, e.g. a client from NewClientForBroker with a nil TLS
+// config. If dialer implements proxy.ContextDialer, dialing is bounded by the client's connect timeout.
+//
+// WithDialer sets the client's custom open connection function, so it's an error to combine it with WithDNSCache or
+// any other option that does. To look up the broker's host name with a DNSCache and connect through a proxy, give the
+// cache the proxy's dialer with DNSCache.SetDialer and use WithDNSCache alone.
+func WithDialer(dialer proxy.Dialer) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		return setOpenConnection(opts, "WithDialer", openConnection(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialContext(ctx, dialer, network, addr)
+		}))
+	}
+}
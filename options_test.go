@@ -0,0 +1,339 @@
+package iothub
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestWithProtocolVersion(t *testing.T) {
+	cases := []struct {
+		version uint
+		wantErr bool
+	}{
+		{3, false},
+		{4, false},
+		{5, true},
+		{0, true},
+	}
+
+	for _, c := range cases {
+		opts := mqtt.NewClientOptions()
+		err := WithProtocolVersion(c.version)(&device, opts)
+		if (err != nil) != c.wantErr {
+			t.Errorf("version %d: got error %v, want error: %v", c.version, err, c.wantErr)
+			continue
+		}
+
+		if !c.wantErr && opts.ProtocolVersion != c.version {
+			t.Errorf("got version %d, want %d", opts.ProtocolVersion, c.version)
+		}
+	}
+}
+
+func TestRecommendedKeepAlive(t *testing.T) {
+	got := RecommendedKeepAlive()
+	if max := ServerIdleTimeout * 2 / 3; got <= 0 || got >= max {
+		t.Errorf("got %v, want between 0 and %v", got, max)
+	}
+}
+
+func TestWithIoTHubDefaults(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	if err := WithIoTHubDefaults()(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if opts.ProtocolVersion != 4 {
+		t.Errorf("got protocol version %d, want 4", opts.ProtocolVersion)
+	}
+	if got, want := time.Duration(opts.KeepAlive)*time.Second, RecommendedKeepAlive(); got != want {
+		t.Errorf("got keep-alive %v, want %v", got, want)
+	}
+}
+
+func TestWithJitteredKeepAlive(t *testing.T) {
+	base, jitter := 4*time.Minute, 30*time.Second
+	for i := 0; i < 100; i++ {
+		opts := mqtt.NewClientOptions()
+		if err := WithJitteredKeepAlive(base, jitter)(&device, opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got := time.Duration(opts.KeepAlive) * time.Second
+		if got < base-jitter || got > base+jitter {
+			t.Fatalf("got keep-alive %v, want in [%v, %v]", got, base-jitter, base+jitter)
+		}
+	}
+}
+
+func TestWithJitteredKeepAliveInvalid(t *testing.T) {
+	cases := []struct {
+		base, jitter time.Duration
+	}{
+		{time.Minute, -time.Second},
+		{time.Minute, time.Minute},
+		{time.Minute, 2 * time.Minute},
+	}
+
+	for _, c := range cases {
+		if err := WithJitteredKeepAlive(c.base, c.jitter)(&device, mqtt.NewClientOptions()); err == nil {
+			t.Errorf("base %v, jitter %v: expected error", c.base, c.jitter)
+		}
+	}
+}
+
+func TestWithWriteTimeout(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	if err := WithWriteTimeout(5*time.Second)(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.WriteTimeout != 5*time.Second {
+		t.Errorf("got write timeout %v, want %v", opts.WriteTimeout, 5*time.Second)
+	}
+}
+
+func TestWithTimeouts(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	if err := WithTimeouts(5*time.Second, 2*time.Second)(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if opts.ConnectTimeout != 5*time.Second {
+		t.Errorf("got connect timeout %v, want %v", opts.ConnectTimeout, 5*time.Second)
+	}
+	if opts.WriteTimeout != 2*time.Second {
+		t.Errorf("got write timeout %v, want %v", opts.WriteTimeout, 2*time.Second)
+	}
+}
+
+func TestWithMaxInflight(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	if err := WithMaxInflight(10)(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if opts.MaxResumePubInFlight != 10 {
+		t.Errorf("got %d, want %d", opts.MaxResumePubInFlight, 10)
+	}
+
+	if err := WithMaxInflight(-1)(&device, opts); err == nil {
+		t.Error("expected error for negative max in-flight")
+	}
+}
+
+func TestWithFileStore(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	if err := WithFileStore(t.TempDir())(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := opts.Store.(*mqtt.FileStore); !ok {
+		t.Errorf("got store of type %T, want *mqtt.FileStore", opts.Store)
+	}
+}
+
+func TestWithMemoryStore(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	if err := WithMemoryStore()(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := opts.Store.(*mqtt.MemoryStore); !ok {
+		t.Errorf("got store of type %T, want *mqtt.MemoryStore", opts.Store)
+	}
+}
+
+func TestWithServerName(t *testing.T) {
+	d := newTestDevice(t)
+	client, err := d.NewClient(WithServerName("gateway.example.com"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := client.OptionsReader()
+	if got := r.TLSConfig().ServerName; got != "gateway.example.com" {
+		t.Errorf("got server name %q, want %q", got, "gateway.example.com")
+	}
+}
+
+func TestWithServerNameNoTLSConfig(t *testing.T) {
+	if err := WithServerName("gateway.example.com")(&device, mqtt.NewClientOptions()); err == nil {
+		t.Error("expected error when there's no TLS config")
+	}
+}
+
+func TestWithTLSCipherSuitesAndCurves(t *testing.T) {
+	suites := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+	curves := []tls.CurveID{tls.CurveP384, tls.CurveP256}
+
+	d := newTestDevice(t)
+	client, err := d.NewClient(WithTLSCipherSuites(suites), WithTLSCurves(curves))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := client.OptionsReader()
+	conf := r.TLSConfig()
+	if !reflect.DeepEqual(conf.CipherSuites, suites) {
+		t.Errorf("got cipher suites %v, want %v", conf.CipherSuites, suites)
+	}
+	if !reflect.DeepEqual(conf.CurvePreferences, curves) {
+		t.Errorf("got curves %v, want %v", conf.CurvePreferences, curves)
+	}
+}
+
+func TestWithTLSCipherSuitesAndCurvesNoTLSConfig(t *testing.T) {
+	if err := WithTLSCipherSuites(nil)(&device, mqtt.NewClientOptions()); err == nil {
+		t.Error("expected error from WithTLSCipherSuites when there's no TLS config")
+	}
+	if err := WithTLSCurves(nil)(&device, mqtt.NewClientOptions()); err == nil {
+		t.Error("expected error from WithTLSCurves when there's no TLS config")
+	}
+}
+
+func TestWithDefaultPublishHandler(t *testing.T) {
+	var got string
+	h := func(client mqtt.Client, msg mqtt.Message) {
+		got = msg.Topic()
+	}
+
+	opts := mqtt.NewClientOptions()
+	if err := WithDefaultPublishHandler(h)(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if opts.DefaultPublishHandler == nil {
+		t.Fatal("got nil default publish handler")
+	}
+	opts.DefaultPublishHandler(nil, &fakeMessage{topic: "foo"})
+	if got != "foo" {
+		t.Errorf("default publish handler isn't the one given")
+	}
+}
+
+func TestWithConcurrentHandlers(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	if !opts.Order {
+		t.Fatal("OrderMatters is false by default; test is invalid")
+	}
+
+	if err := WithConcurrentHandlers()(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.Order {
+		t.Error("got OrderMatters true, want false")
+	}
+}
+
+func TestWithResumeSubs(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	if err := WithResumeSubs()(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !opts.ResumeSubs {
+		t.Error("got ResumeSubs false, want true")
+	}
+}
+
+// recordingDialer dials addr regardless of the address it's asked for, recording the requested addresses.
+type recordingDialer struct {
+	addr  string
+	dials []string
+}
+
+func (d *recordingDialer) Dial(network, addr string) (net.Conn, error) {
+	d.dials = append(d.dials, addr)
+	return net.Dial(network, d.addr)
+}
+
+func TestWithDialer(t *testing.T) {
+	port, pool := newTLSListener(t, "myhub.azure-devices.net")
+
+	dialer := &recordingDialer{addr: net.JoinHostPort("127.0.0.1", port)}
+	opts := mqtt.NewClientOptions()
+	opts.SetTLSConfig(&tls.Config{RootCAs: pool})
+	if err := WithDialer(dialer)(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.CustomOpenConnectionFn == nil {
+		t.Fatal("custom open connection function isn't set")
+	}
+
+	uri := &url.URL{Scheme: "tls", Host: "myhub.azure-devices.net:8883"}
+	conn, err := opts.CustomOpenConnectionFn(uri, *opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	if !conn.(*tls.Conn).ConnectionState().HandshakeComplete {
+		t.Error("TLS handshake isn't complete")
+	}
+	if want := []string{"myhub.azure-devices.net:8883"}; !reflect.DeepEqual(dialer.dials, want) {
+		t.Errorf("got dials %v, want %v", dialer.dials, want)
+	}
+}
+
+func TestWithDialerAndDNSCache(t *testing.T) {
+	cache := NewDNSCache(&fakeResolver{addrs: []string{"127.0.0.1"}}, time.Minute)
+	dialer := &recordingDialer{addr: "127.0.0.1:1"}
+
+	cases := []struct {
+		name    string
+		options []func(*Device, *mqtt.ClientOptions) error
+	}{
+		{"dialer then cache", []func(*Device, *mqtt.ClientOptions) error{WithDialer(dialer), WithDNSCache(cache)}},
+		{"cache then dialer", []func(*Device, *mqtt.ClientOptions) error{WithDNSCache(cache), WithDialer(dialer)}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := mqtt.NewClientOptions()
+			if err := c.options[0](&device, opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := c.options[1](&device, opts); err == nil {
+				t.Error("got nil error combining WithDialer and WithDNSCache, want error")
+			}
+		})
+	}
+}
+
+func TestDNSCacheSetDialer(t *testing.T) {
+	port, pool := newTLSListener(t, "myhub.azure-devices.net")
+
+	r := &fakeResolver{addrs: []string{"192.0.2.1"}}
+	dialer := &recordingDialer{addr: net.JoinHostPort("127.0.0.1", port)}
+	cache := NewDNSCache(r, time.Minute)
+	cache.SetDialer(dialer)
+
+	opts := mqtt.NewClientOptions()
+	opts.SetTLSConfig(&tls.Config{RootCAs: pool})
+	if err := WithDNSCache(cache)(&device, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	uri := &url.URL{Scheme: "tls", Host: "myhub.azure-devices.net:8883"}
+	conn, err := opts.CustomOpenConnectionFn(uri, *opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	if !conn.(*tls.Conn).ConnectionState().HandshakeComplete {
+		t.Error("TLS handshake isn't complete")
+	}
+	// The proxy's dialer is asked for the address that the cache looked up.
+	if want := []string{"192.0.2.1:8883"}; !reflect.DeepEqual(dialer.dials, want) {
+		t.Errorf("got dials %v, want %v", dialer.dials, want)
+	}
+	if r.lookups != 1 {
+		t.Errorf("got %d lookups, want 1", r.lookups)
+	}
+}
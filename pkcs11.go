@@ -0,0 +1,82 @@
+//go:build pkcs11
+
+package iothub
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// PKCS11Certificate returns a client cert whose private key is held in a PKCS #11 token such as a smartcard or HSM,
+// for use as Device.ClientCert. The key never leaves the token; the TLS handshake asks the token to sign with it.
+//
+// uri is a PKCS #11 URI (RFC 7512) that names the module to load, the token, the key, and where to get the PIN, e.g.
+//
+//	pkcs11:token=gateway;object=device-key?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/etc/iothub/pin
+//
+// The module is given by the module-path query attribute, the token by token, serial, or slot-id, and the key by id,
+// object, or both. The PIN is given by pin-value or, so that it doesn't appear in configuration, read from the file
+// named by pin-source. The device's cert is read from the token too: it's the cert object with the same id and
+// object as the key.
+//
+// The returned Closer releases the token and unloads the module. Close it when the client is no longer needed; until
+// then the token is kept open so that reconnects can use the key.
+//
+// PKCS #11 support requires cgo and is only built with the pkcs11 build tag, e.g. go build -tags pkcs11.
+func PKCS11Certificate(uri string) (*tls.Certificate, io.Closer, error) {
+	u, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	pin, err := u.loginPIN()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:        u.modulePath,
+		TokenLabel:  u.token,
+		TokenSerial: u.serial,
+		SlotNumber:  u.slot,
+		Pin:         pin,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("iothub: failed to open PKCS #11 token: %w", err)
+	}
+
+	cert, err := pkcs11Certificate(ctx, u)
+	if err != nil {
+		ctx.Close()
+		return nil, nil, err
+	}
+	return cert, ctx, nil
+}
+
+// pkcs11Certificate finds the key and cert identified by u in the token opened by ctx.
+func pkcs11Certificate(ctx *crypto11.Context, u pkcs11URI) (*tls.Certificate, error) {
+	var label []byte
+	if u.object != "" {
+		label = []byte(u.object)
+	}
+
+	signer, err := ctx.FindKeyPair(u.id, label)
+	if err != nil {
+		return nil, fmt.Errorf("iothub: failed to find PKCS #11 key: %w", err)
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("iothub: no PKCS #11 key matches the URI")
+	}
+
+	leaf, err := ctx.FindCertificate(u.id, label, nil)
+	if err != nil {
+		return nil, fmt.Errorf("iothub: failed to find PKCS #11 cert: %w", err)
+	}
+	if leaf == nil {
+		return nil, fmt.Errorf("iothub: no PKCS #11 cert matches the URI")
+	}
+
+	return SignerCertificate(signer, leaf)
+}
@@ -0,0 +1,140 @@
+package iothub
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pkcs11URI holds the parts of a PKCS #11 URI (RFC 7512) that identify a device's key: the module to load, the token
+// that holds the key, the key object, and the PIN with which to log in to the token.
+type pkcs11URI struct {
+	modulePath string
+
+	// token, serial, and slot identify the token. At most one is set.
+	token  string
+	serial string
+	slot   *int
+
+	// id and object are the key's CKA_ID and CKA_LABEL. At least one is set.
+	id     []byte
+	object string
+
+	pin       string
+	pinSource string
+}
+
+// parsePKCS11URI parses a PKCS #11 URI such as
+//
+//	pkcs11:token=gateway;object=device-key?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/etc/iothub/pin
+//
+// Of the path attributes, token, serial, slot-id, id, and object are used; of the query attributes, module-path,
+// pin-value, and pin-source are used. Other attributes are ignored. module-path is required, as are one of token,
+// serial, and slot-id and one or both of id and object.
+// See https://www.rfc-editor.org/rfc/rfc7512.
+func parsePKCS11URI(uri string) (pkcs11URI, error) {
+	rest, ok := strings.CutPrefix(uri, "pkcs11:")
+	if !ok {
+		return pkcs11URI{}, fmt.Errorf("iothub: PKCS #11 URI must start with \"pkcs11:\"")
+	}
+	path, query, _ := strings.Cut(rest, "?")
+
+	var u pkcs11URI
+	attrs := func(s, sep string, set func(name, value string) error) error {
+		for _, attr := range strings.Split(s, sep) {
+			if attr == "" {
+				continue
+			}
+
+			name, value, ok := strings.Cut(attr, "=")
+			if !ok {
+				return fmt.Errorf("iothub: malformed PKCS #11 URI attribute %q", attr)
+			}
+			value, err := url.PathUnescape(value)
+			if err != nil {
+				return fmt.Errorf("iothub: malformed PKCS #11 URI attribute %q: %v", attr, err)
+			}
+			if err := set(name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := attrs(path, ";", func(name, value string) error {
+		switch name {
+		case "token":
+			u.token = value
+		case "serial":
+			u.serial = value
+		case "slot-id":
+			slot, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("iothub: malformed PKCS #11 URI slot-id %q", value)
+			}
+			u.slot = &slot
+		case "id":
+			u.id = []byte(value)
+		case "object":
+			u.object = value
+		}
+		return nil
+	})
+	if err != nil {
+		return pkcs11URI{}, err
+	}
+
+	err = attrs(query, "&", func(name, value string) error {
+		switch name {
+		case "module-path":
+			u.modulePath = value
+		case "pin-value":
+			u.pin = value
+		case "pin-source":
+			u.pinSource = value
+		}
+		return nil
+	})
+	if err != nil {
+		return pkcs11URI{}, err
+	}
+
+	tokens := 0
+	for _, set := range []bool{u.token != "", u.serial != "", u.slot != nil} {
+		if set {
+			tokens++
+		}
+	}
+	switch {
+	case u.modulePath == "":
+		return pkcs11URI{}, fmt.Errorf("iothub: PKCS #11 URI must have a module-path")
+	case tokens != 1:
+		return pkcs11URI{}, fmt.Errorf("iothub: PKCS #11 URI must have exactly one of token, serial, and slot-id")
+	case u.id == nil && u.object == "":
+		return pkcs11URI{}, fmt.Errorf("iothub: PKCS #11 URI must have an id or object")
+	case u.pin != "" && u.pinSource != "":
+		return pkcs11URI{}, fmt.Errorf("iothub: PKCS #11 URI may have only one of pin-value and pin-source")
+	}
+
+	return u, nil
+}
+
+// loginPIN returns the PIN with which to log in to the token: pin-value, or the contents of the file named by
+// pin-source, less a trailing newline. pin-source may be a path or a file: URI.
+func (u pkcs11URI) loginPIN() (string, error) {
+	if u.pinSource == "" {
+		return u.pin, nil
+	}
+
+	path := u.pinSource
+	if p, ok := strings.CutPrefix(path, "file:"); ok {
+		path = p
+	}
+	pin, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("iothub: failed to read PKCS #11 PIN: %w", err)
+	}
+	return strings.TrimRight(string(pin), "\r\n"), nil
+}
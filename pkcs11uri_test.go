@@ -0,0 +1,94 @@
+package iothub
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParsePKCS11URI(t *testing.T) {
+	slot := 2
+
+	cases := []struct {
+		uri  string
+		want pkcs11URI
+	}{
+		{
+			"pkcs11:token=gateway;object=device-key?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234",
+			pkcs11URI{modulePath: "/usr/lib/softhsm/libsofthsm2.so", token: "gateway", object: "device-key", pin: "1234"},
+		},
+		{
+			"pkcs11:serial=abc123;id=%01%02;type=private?module-path=/usr/lib/p11.so&pin-source=file:/etc/pin",
+			pkcs11URI{modulePath: "/usr/lib/p11.so", serial: "abc123", id: []byte{1, 2}, pinSource: "file:/etc/pin"},
+		},
+		{
+			"pkcs11:slot-id=2;object=My%20Key?module-path=/usr/lib/p11.so",
+			pkcs11URI{modulePath: "/usr/lib/p11.so", slot: &slot, object: "My Key"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.uri, func(t *testing.T) {
+			got, err := parsePKCS11URI(c.uri)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			slotsEqual := got.slot == c.want.slot || (got.slot != nil && c.want.slot != nil && *got.slot == *c.want.slot)
+			if got.modulePath != c.want.modulePath || got.token != c.want.token || got.serial != c.want.serial ||
+				!slotsEqual || !bytes.Equal(got.id, c.want.id) || got.object != c.want.object ||
+				got.pin != c.want.pin || got.pinSource != c.want.pinSource {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParsePKCS11URIErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		uri  string
+	}{
+		{"not PKCS #11", "file:/etc/key.pem"},
+		{"no module", "pkcs11:token=gateway;object=key"},
+		{"no token", "pkcs11:object=key?module-path=/p11.so"},
+		{"two tokens", "pkcs11:token=gateway;serial=abc;object=key?module-path=/p11.so"},
+		{"no key", "pkcs11:token=gateway?module-path=/p11.so"},
+		{"bad slot", "pkcs11:slot-id=x;object=key?module-path=/p11.so"},
+		{"bad escape", "pkcs11:token=gateway;object=%zz?module-path=/p11.so"},
+		{"malformed attribute", "pkcs11:token;object=key?module-path=/p11.so"},
+		{"two PINs", "pkcs11:token=gateway;object=key?module-path=/p11.so&pin-value=1&pin-source=/pin"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := parsePKCS11URI(c.uri); err == nil {
+				t.Error("got nil error, want error")
+			}
+		})
+	}
+}
+
+func TestPKCS11LoginPIN(t *testing.T) {
+	path := writeTestFile(t, "pin", []byte("1234\n"))
+
+	cases := []struct {
+		name string
+		u    pkcs11URI
+	}{
+		{"value", pkcs11URI{pin: "1234"}},
+		{"source path", pkcs11URI{pinSource: path}},
+		{"source URI", pkcs11URI{pinSource: "file:" + path}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.u.loginPIN()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != "1234" {
+				t.Errorf("got PIN %q, want %q", got, "1234")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package iothub
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TelemetryProperties are the properties of a device-to-cloud message. IoT Hub makes them available to message
+// routing queries. The system properties are those that IoT Hub itself defines; Custom holds application properties.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-devguide-messages-construct.
+type TelemetryProperties struct {
+	MessageID       string
+	CorrelationID   string
+	UserID          string
+	ContentType     string
+	ContentEncoding string
+	// Component is the name of the IoT Plug and Play component that the telemetry belongs to, for devices whose model
+	// has more than one component. Leave it empty for telemetry from the default component.
+	// See https://learn.microsoft.com/en-us/azure/iot-develop/concepts-convention.
+	Component string
+	// Expiry is when the message expires. IoT Hub discards a message that hasn't been delivered to its endpoints by
+	// then. If it's the zero time the message doesn't expire.
+	Expiry time.Time
+	Custom map[string]string
+}
+
+// Encode returns the properties encoded as a property bag, suitable for appending to the device's telemetry topic.
+// System property keys are prefixed with "$." as IoT Hub requires. Empty properties are omitted. Custom properties
+// come after the system properties, sorted by key.
+func (p TelemetryProperties) Encode() string {
+	var pairs []string
+	system := []struct{ k, v string }{
+		{"$.mid", p.MessageID},
+		{"$.cid", p.CorrelationID},
+		{"$.uid", p.UserID},
+		{"$.ct", p.ContentType},
+		{"$.ce", p.ContentEncoding},
+		{"$.sub", p.Component},
+		{"$.exp", formatExpiry(p.Expiry)},
+	}
+	for _, kv := range system {
+		if kv.v != "" {
+			pairs = append(pairs, escape(kv.k)+"="+escape(kv.v))
+		}
+	}
+
+	custom := make(map[string]string, len(p.Custom))
+	for k, v := range p.Custom {
+		if v != "" {
+			custom[k] = v
+		}
+	}
+	if bag := encodePropertyBag(custom); bag != "" {
+		pairs = append(pairs, bag)
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// formatExpiry formats t as an ISO 8601 time in UTC for the $.exp system property, or returns "" if t is the zero
+// time.
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// encodePropertyBag encodes m as a property bag, sorted by key. It's the inverse of decodePropertyBag.
+func encodePropertyBag(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = escape(k) + "=" + escape(m[k])
+	}
+	return strings.Join(pairs, "&")
+}
+
+// decodePropertyBag decodes a property bag, such as the one at the end of a cloud-to-device message's topic, into a
+// map. If a key appears more than once the first value is used.
+func decodePropertyBag(s string) (map[string]string, error) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string, len(values))
+	for k, v := range values {
+		m[k] = v[0]
+	}
+	return m, nil
+}
+
+// escape URL-encodes s for use as a key or value in a property bag. Spaces are encoded as %20 rather than +.
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,78 @@
+package iothub
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTelemetryPropertiesEncode(t *testing.T) {
+	cases := []struct {
+		name  string
+		props TelemetryProperties
+		want  string
+	}{
+		{"empty", TelemetryProperties{}, ""},
+		{"MessageID", TelemetryProperties{MessageID: "m1"}, "%24.mid=m1"},
+		{"CorrelationID", TelemetryProperties{CorrelationID: "c1"}, "%24.cid=c1"},
+		{"UserID", TelemetryProperties{UserID: "u1"}, "%24.uid=u1"},
+		{"ContentType", TelemetryProperties{ContentType: "application/json"}, "%24.ct=application%2Fjson"},
+		{"ContentEncoding", TelemetryProperties{ContentEncoding: "utf-8"}, "%24.ce=utf-8"},
+		{"Component", TelemetryProperties{Component: "thermostat1"}, "%24.sub=thermostat1"},
+		{
+			"Expiry",
+			TelemetryProperties{Expiry: time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("PST", -8*60*60))},
+			"%24.exp=2024-03-01T20%3A30%3A00Z",
+		},
+		{"Custom", TelemetryProperties{Custom: map[string]string{"b": "x y", "a": "1&2"}}, "a=1%262&b=x%20y"},
+		{
+			"all",
+			TelemetryProperties{
+				MessageID:       "m1",
+				CorrelationID:   "c1",
+				UserID:          "u1",
+				ContentType:     "application/json",
+				ContentEncoding: "utf-8",
+				Component:       "thermostat1",
+				Expiry:          time.Date(2024, 3, 1, 20, 30, 0, 500000000, time.UTC),
+				Custom:          map[string]string{"alert": "true"},
+			},
+			"%24.mid=m1&%24.cid=c1&%24.uid=u1&%24.ct=application%2Fjson&%24.ce=utf-8&%24.sub=thermostat1&%24.exp=2024-03-01T20%3A30%3A00.5Z&alert=true",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.props.Encode()
+			if got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPropertyBagRoundTrip(t *testing.T) {
+	cases := []map[string]string{
+		{},
+		{"a": "1"},
+		{"$.mid": "m1", "$.ct": "application/json"},
+		{"a b": "x y", "a&b": "x=y", "plus": "1+1", "percent": "100%", "unicode": "héllo", "slash": "a/b", "empty": ""},
+	}
+
+	for _, m := range cases {
+		bag := encodePropertyBag(m)
+		got, err := decodePropertyBag(bag)
+		if err != nil {
+			t.Fatalf("decodePropertyBag(%q) returned error: %v", bag, err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("round trip through %q: got %v, want %v", bag, got, m)
+		}
+	}
+}
+
+func TestEncodePropertyBagEscapesDollar(t *testing.T) {
+	if got, want := encodePropertyBag(map[string]string{"$.mid": "m1"}), "%24.mid=m1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
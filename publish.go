@@ -0,0 +1,72 @@
+package iothub
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MaxMessageBytes is the maximum size of a device-to-cloud message, including its properties, that IoT Hub accepts.
+// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-devguide-quotas-throttling#other-limits.
+const MaxMessageBytes = 256 * 1024
+
+// Publish publishes payload to the given topic and waits for the publish to complete.
+//
+// IoT Hub does not support the MQTT retain flag and closes the connection of any client that sets it. Rather than
+// letting the connection die, Publish returns ErrRetained without publishing if retained is true.
+// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#sending-device-to-cloud-messages.
+//
+// IoT Hub also rejects messages larger than MaxMessageBytes, counting both the payload and the property bag encoded
+// in the telemetry topic, by disconnecting the client. Publish returns ErrMessageTooLarge without publishing if the
+// message is too large.
+func (d *Device) Publish(client mqtt.Client, topic string, qos byte, retained bool, payload []byte) error {
+	if err := d.checkPublish(topic, retained, payload); err != nil {
+		return err
+	}
+
+	token := client.Publish(topic, qos, retained, payload)
+	token.Wait()
+	return publishError(token.Error())
+}
+
+// pahoPublishTimeout is the message of the error paho gives a publish token when the publish can't be queued for
+// writing within the write timeout.
+const pahoPublishTimeout = "publish was broken by timeout"
+
+// publishError wraps err, an error from publishing, with ErrWriteTimeout if it's due to the write timeout.
+func publishError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, os.ErrDeadlineExceeded) || strings.Contains(err.Error(), pahoPublishTimeout) {
+		return fmt.Errorf("%w: %w", ErrWriteTimeout, err)
+	}
+	return err
+}
+
+// checkPublish returns an error if IoT Hub would reject the message, as described by Publish.
+func (d *Device) checkPublish(topic string, retained bool, payload []byte) error {
+	if retained {
+		return ErrRetained
+	}
+
+	if d.messageSize(topic, payload) > MaxMessageBytes {
+		return ErrMessageTooLarge
+	}
+
+	return nil
+}
+
+// messageSize returns the size of the message as IoT Hub counts it against MaxMessageBytes: the size of the payload
+// plus the size of the property bag, if the topic is a telemetry topic with one.
+func (d *Device) messageSize(topic string, payload []byte) int {
+	size := len(payload)
+	if strings.HasPrefix(topic, d.TelemetryTopic()) {
+		size += len(topic) - len(d.TelemetryTopic())
+	}
+	return size
+}
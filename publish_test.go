@@ -0,0 +1,93 @@
+package iothub
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPublish(t *testing.T) {
+	client := &fakeClient{}
+	if err := device.Publish(client, device.TelemetryTopic(), 1, false, []byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(client.published) != 1 {
+		t.Fatalf("got %d published messages, want 1", len(client.published))
+	}
+	if got, want := client.published[0].topic, device.TelemetryTopic(); got != want {
+		t.Errorf("got topic %q, want %q", got, want)
+	}
+}
+
+func TestPublishRetained(t *testing.T) {
+	client := &fakeClient{}
+	err := device.Publish(client, device.TelemetryTopic(), 1, true, []byte("hello"))
+	if !errors.Is(err, ErrRetained) {
+		t.Errorf("got error %v, want %v", err, ErrRetained)
+	}
+
+	if len(client.published) != 0 {
+		t.Errorf("got %d published messages, want 0", len(client.published))
+	}
+}
+
+func TestPublishSizeLimit(t *testing.T) {
+	props := TelemetryProperties{ContentType: "application/json"}
+	bagSize := len(props.Encode())
+
+	cases := []struct {
+		name        string
+		topic       string
+		payloadSize int
+		wantErr     bool
+	}{
+		{"at limit", device.TelemetryTopic(), MaxMessageBytes, false},
+		{"over limit", device.TelemetryTopic(), MaxMessageBytes + 1, true},
+		{"at limit with properties", device.TelemetryTopicWithProperties(props), MaxMessageBytes - bagSize, false},
+		{"over limit with properties", device.TelemetryTopicWithProperties(props), MaxMessageBytes - bagSize + 1, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := &fakeClient{}
+			err := device.Publish(client, c.topic, 1, false, make([]byte, c.payloadSize))
+
+			if c.wantErr {
+				if !errors.Is(err, ErrMessageTooLarge) {
+					t.Errorf("got error %v, want %v", err, ErrMessageTooLarge)
+				}
+				if len(client.published) != 0 {
+					t.Errorf("got %d published messages, want 0", len(client.published))
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestPublishWriteTimeout(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"paho timeout", errors.New("publish was broken by timeout"), ErrWriteTimeout},
+		{"deadline exceeded", os.ErrDeadlineExceeded, ErrWriteTimeout},
+		{"other", errors.New("connection lost"), nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := &fakeClient{publishErr: c.err}
+			err := device.Publish(client, device.TelemetryTopic(), 1, false, []byte("hello"))
+			if !errors.Is(err, c.err) {
+				t.Errorf("got error %v that doesn't wrap %v", err, c.err)
+			}
+			if got := errors.Is(err, ErrWriteTimeout); got != (c.want != nil) {
+				t.Errorf("got error %v, want ErrWriteTimeout: %v", err, c.want != nil)
+			}
+		})
+	}
+}
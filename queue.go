@@ -0,0 +1,208 @@
+package iothub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// publishQueuePoll is how often a PublishQueue checks whether the client has connected, and how long it waits before
+// retrying a failed publish. It's a variable so that tests can shorten it.
+var publishQueuePoll = time.Second
+
+// Message is a telemetry message to be published.
+type Message struct {
+	Payload    []byte
+	Properties TelemetryProperties
+	// Gzip, if true, compresses the payload with gzip when the message is published and sets its ContentEncoding to
+	// "gzip", saving bandwidth for large payloads such as JSON on metered links. The size limit (see Device.Publish)
+	// applies to the compressed payload.
+	//
+	// IoT Hub doesn't decompress messages, so whatever consumes them, e.g. a message route's endpoint, must check the
+	// content encoding and decompress them itself.
+	Gzip bool
+}
+
+// encoded returns the message as it's published: if Gzip is set, with its payload compressed and its ContentEncoding
+// set, and otherwise unchanged.
+func (m Message) encoded() (Message, error) {
+	if !m.Gzip {
+		return m, nil
+	}
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write(m.Payload); err != nil {
+		return Message{}, fmt.Errorf("iothub: failed to compress payload: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return Message{}, fmt.Errorf("iothub: failed to compress payload: %w", err)
+	}
+
+	m.Payload = b.Bytes()
+	m.Properties.ContentEncoding = "gzip"
+	m.Gzip = false
+	return m, nil
+}
+
+// PublishQueue holds telemetry messages while the client is disconnected and publishes them, in order, once it's
+// connected, e.g. to ride out brief network outages. Messages are published to the device's telemetry topic with its
+// DefaultQoS. A message whose publish fails is retried until it succeeds or the queue is closed.
+//
+// The queue is held in memory, so messages that haven't been published are lost if the process exits. Call Close on
+// shutdown to give the queue a chance to drain.
+type PublishQueue struct {
+	d      *Device
+	client mqtt.Client
+	qos    byte
+
+	msgs    chan Message
+	closing chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+
+	// undelivered is the number of messages left unpublished when the drain loop stopped, including one whose
+	// publish was abandoned. It's set before done is closed.
+	undelivered int
+}
+
+// NewPublishQueue returns a PublishQueue that publishes with client and holds up to capacity messages, and starts
+// draining it in a new goroutine. capacity must be positive, and the device's DefaultQoS must be valid.
+func (d *Device) NewPublishQueue(client mqtt.Client, capacity int) (*PublishQueue, error) {
+	if capacity < 1 {
+		return nil, fmt.Errorf("iothub: publish queue capacity must be positive, got %d", capacity)
+	}
+
+	qos, err := d.defaultQoS()
+	if err != nil {
+		return nil, err
+	}
+
+	q := &PublishQueue{
+		d:       d,
+		client:  client,
+		qos:     qos,
+		msgs:    make(chan Message, capacity),
+		closing: make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q, nil
+}
+
+// Enqueue adds msg to the queue without blocking. It returns ErrQueueFull if the queue is at capacity and
+// ErrQueueClosed if Close has been called. Like Device.Publish, it returns ErrMessageTooLarge if IoT Hub would reject
+// the message. A message with Gzip set is compressed before it's queued.
+func (q *PublishQueue) Enqueue(msg Message) error {
+	msg, err := msg.encoded()
+	if err != nil {
+		return err
+	}
+	if err := q.d.checkPublish(q.d.TelemetryTopicWithProperties(msg.Properties), false, msg.Payload); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	select {
+	case q.msgs <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops the queue accepting messages and waits for the ones it holds to be published. If the context is done
+// first, it gives up and returns an error, wrapping the context's error, that says how many messages weren't
+// published. Calling Close more than once has no further effect.
+func (q *PublishQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.closing)
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		close(q.stop)
+		<-q.done
+		return fmt.Errorf("iothub: publish queue closed with %d messages unpublished: %w", q.undelivered, ctx.Err())
+	}
+}
+
+// run publishes messages from the queue until it's closed and empty, or until stop is closed.
+func (q *PublishQueue) run() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(publishQueuePoll)
+	defer ticker.Stop()
+
+	var pending *Message
+	defer func() {
+		q.undelivered = len(q.msgs)
+		if pending != nil {
+			q.undelivered++
+		}
+	}()
+
+	for {
+		if pending == nil {
+			select {
+			case msg := <-q.msgs:
+				pending = &msg
+			case <-q.closing:
+				select {
+				case msg := <-q.msgs:
+					pending = &msg
+				default:
+					return
+				}
+			case <-q.stop:
+				return
+			}
+		}
+
+		if q.client.IsConnectionOpen() && q.publish(pending) {
+			pending = nil
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// publish publishes msg and reports whether the publish succeeded. It stops waiting for the publish to complete if
+// stop is closed: with auto-reconnect on, paho keeps a publish token pending across a dropped connection, possibly
+// forever.
+func (q *PublishQueue) publish(msg *Message) bool {
+	topic := q.d.TelemetryTopicWithProperties(msg.Properties)
+	token := q.client.Publish(topic, q.qos, false, msg.Payload)
+	select {
+	case <-token.Done():
+	case <-q.stop:
+		return false
+	}
+	return token.Error() == nil
+}
@@ -0,0 +1,184 @@
+package iothub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func shortPublishQueuePoll(tb testing.TB) {
+	tb.Helper()
+	orig := publishQueuePoll
+	publishQueuePoll = time.Millisecond
+	tb.Cleanup(func() { publishQueuePoll = orig })
+}
+
+func TestPublishQueueDrainOnReconnect(t *testing.T) {
+	shortPublishQueuePoll(t)
+
+	client := &fakeClient{}
+	q, err := device.NewPublishQueue(client, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := q.Enqueue(Message{Payload: []byte(fmt.Sprint(i))}); err != nil {
+			t.Fatalf("Enqueue %d: unexpected error: %v", i, err)
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	client.mu.Lock()
+	n := len(client.published)
+	client.connected = true
+	client.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("got %d messages published while disconnected, want 0", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Close(ctx); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+
+	if len(client.published) != 3 {
+		t.Fatalf("got %d published messages, want 3", len(client.published))
+	}
+	for i, m := range client.published {
+		if got, want := string(m.payload), fmt.Sprint(i); got != want {
+			t.Errorf("message %d: got payload %q, want %q", i, got, want)
+		}
+		if got, want := m.topic, device.TelemetryTopic(); got != want {
+			t.Errorf("message %d: got topic %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestPublishQueueGzip(t *testing.T) {
+	shortPublishQueuePoll(t)
+
+	client := &fakeClient{connected: true}
+	q, err := device.NewPublishQueue(client, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The payload is over the size limit before compression but well under it after.
+	payload := bytes.Repeat([]byte(`{"temp":21.5}`), MaxMessageBytes/10)
+	if err := q.Enqueue(Message{Payload: payload, Gzip: true}); err != nil {
+		t.Fatalf("Enqueue: unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Close(ctx); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+
+	if len(client.published) != 1 {
+		t.Fatalf("got %d published messages, want 1", len(client.published))
+	}
+	if got, want := client.published[0].topic, "devices/foo/messages/events/%24.ce=gzip"; got != want {
+		t.Errorf("got topic %q, want %q", got, want)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(client.published[0].payload))
+	if err != nil {
+		t.Fatalf("published payload isn't gzipped: %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress published payload: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("decompressed payload doesn't match the original")
+	}
+}
+
+func TestPublishQueueFull(t *testing.T) {
+	client := &fakeClient{}
+	q, err := device.NewPublishQueue(client, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The drain loop takes at most one message off the queue while disconnected, so with capacity 1 the third
+	// message can't fit.
+	var errs []error
+	for i := 0; i < 3; i++ {
+		errs = append(errs, q.Enqueue(Message{Payload: []byte("hello")}))
+	}
+	if err := errs[2]; !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got error %v, want %v", err, ErrQueueFull)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = q.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	accepted := 0
+	for _, err := range errs {
+		if err == nil {
+			accepted++
+		}
+	}
+	if want := fmt.Sprintf("%d messages unpublished", accepted); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Close: got error %v, want it to report %s", err, want)
+	}
+	if len(client.published) != 0 {
+		t.Errorf("got %d published messages, want 0", len(client.published))
+	}
+
+	if err := q.Enqueue(Message{Payload: []byte("hello")}); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("got error %v, want %v", err, ErrQueueClosed)
+	}
+}
+
+func TestPublishQueueCloseWithPendingPublish(t *testing.T) {
+	shortPublishQueuePoll(t)
+
+	client := &fakeClient{connected: true, publishPending: true}
+	q, err := device.NewPublishQueue(client, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := q.Enqueue(Message{Payload: []byte(fmt.Sprint(i))}); err != nil {
+			t.Fatalf("Enqueue %d: unexpected error: %v", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() { errc <- q.Close(ctx) }()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+		if err == nil || !strings.Contains(err.Error(), "3 messages unpublished") {
+			t.Errorf("got error %v, want it to report 3 messages unpublished", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't return after its context was done")
+	}
+}
+
+func TestNewPublishQueueCapacity(t *testing.T) {
+	if _, err := device.NewPublishQueue(&fakeClient{}, 0); err == nil {
+		t.Error("got nil error, want error")
+	}
+}
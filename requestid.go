@@ -0,0 +1,25 @@
+package iothub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+)
+
+var requestCounter uint64
+
+// NewRequestID returns a new request ID for use as the $rid of a twin or direct method request. It's a counter that's
+// unique within the process followed by a random suffix, which makes collisions with IDs generated by other processes
+// (e.g. before a restart) unlikely. It's safe for concurrent use.
+func NewRequestID() string {
+	n := atomic.AddUint64(&requestCounter, 1)
+
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		// The counter alone is still unique within the process.
+		return strconv.FormatUint(n, 36)
+	}
+
+	return strconv.FormatUint(n, 36) + "-" + hex.EncodeToString(suffix[:])
+}
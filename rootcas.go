@@ -0,0 +1,158 @@
+package iothub
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	_ "embed"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// embeddedRootCAs are the roots named in IoTHubTLSRequirements, as of when this package was released.
+//
+//go:embed azure_roots.pem
+var embeddedRootCAs []byte
+
+// azureRootCAURLs are where Microsoft and DigiCert publish the roots named in IoTHubTLSRequirements.
+// See https://learn.microsoft.com/en-us/azure/security/fundamentals/azure-ca-details.
+var azureRootCAURLs = []string{
+	"https://cacerts.digicert.com/DigiCertGlobalRootG2.crt.pem",
+	"https://www.microsoft.com/pkiops/certs/Microsoft%20RSA%20Root%20Certificate%20Authority%202017.crt",
+}
+
+// maxRootCABytes limits the size of each downloaded root cert.
+const maxRootCABytes = 64 * 1024
+
+// FetchAndCacheRootCAs returns a pool of the root CAs to which IoT Hub's server cert chains, for devices that don't
+// ship with a CA certs file. If the file at cachePath holds valid roots they're loaded from it. Otherwise the roots
+// are downloaded from their publishers using httpClient, or http.DefaultClient if it's nil, and written to cachePath
+// in PEM format, which makes it suitable for Device.CACerts.
+//
+// Roots are valid if they're unexpired self-signed CA certs and include every root in
+// IoTHubTLSRequirements().ServerRootCAs. If the download fails, e.g. because the device is offline, a copy of the
+// roots embedded in this package is returned and the cache isn't written, so the download is retried next time.
+func FetchAndCacheRootCAs(ctx context.Context, httpClient *http.Client, cachePath string) (*x509.CertPool, error) {
+	return fetchAndCacheRootCAs(ctx, httpClient, azureRootCAURLs, cachePath)
+}
+
+func fetchAndCacheRootCAs(ctx context.Context, httpClient *http.Client, urls []string, cachePath string) (*x509.CertPool, error) {
+	if cached, err := os.ReadFile(cachePath); err == nil {
+		if pool, err := rootCAPool(cached); err == nil {
+			return pool, nil
+		}
+	}
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	fetched, err := fetchRootCAs(ctx, httpClient, urls)
+	if err != nil {
+		return rootCAPool(embeddedRootCAs)
+	}
+	pool, err := rootCAPool(fetched)
+	if err != nil {
+		return rootCAPool(embeddedRootCAs)
+	}
+
+	if err := writeFileAtomic(cachePath, fetched); err != nil {
+		return nil, fmt.Errorf("iothub: failed to cache root CAs: %w", err)
+	}
+	return pool, nil
+}
+
+// fetchRootCAs downloads the certs at urls, each of which may be PEM or DER encoded, and returns them PEM encoded.
+func fetchRootCAs(ctx context.Context, httpClient *http.Client, urls []string) ([]byte, error) {
+	var out bytes.Buffer
+	for _, u := range urls {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("iothub: root CA request failed: %w", err)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxRootCABytes))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("iothub: failed to read root CA from %s: %w", u, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("iothub: root CA request to %s failed with status %d", u, resp.StatusCode)
+		}
+
+		if block, _ := pem.Decode(body); block != nil {
+			out.Write(body)
+			continue
+		}
+		if _, err := x509.ParseCertificate(body); err != nil {
+			return nil, fmt.Errorf("iothub: malformed root CA from %s: %v", u, err)
+		}
+		pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: body})
+	}
+	return out.Bytes(), nil
+}
+
+// rootCAPool returns a pool of the certs in pemCerts, or an error if they aren't valid roots as described by
+// FetchAndCacheRootCAs.
+func rootCAPool(pemCerts []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	found := make(map[string]bool)
+	for rest := pemCerts; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("iothub: malformed root CA: %v", err)
+		}
+		if !cert.IsCA || cert.CheckSignatureFrom(cert) != nil {
+			return nil, fmt.Errorf("iothub: %q is not a self-signed root CA", cert.Subject.CommonName)
+		}
+		if t := now(); t.Before(cert.NotBefore) || t.After(cert.NotAfter) {
+			return nil, fmt.Errorf("iothub: root CA %q is not valid at %s", cert.Subject.CommonName, t)
+		}
+
+		pool.AddCert(cert)
+		found[cert.Subject.CommonName] = true
+	}
+
+	for _, cn := range IoTHubTLSRequirements().ServerRootCAs {
+		if !found[cn] {
+			return nil, fmt.Errorf("%w: missing %q", ErrNoCACerts, cn)
+		}
+	}
+	return pool, nil
+}
+
+// writeFileAtomic writes data to path by way of a temporary file in the same directory, so that a crash doesn't leave
+// a partially written file at path.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
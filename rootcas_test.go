@@ -0,0 +1,105 @@
+package iothub
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// newRootCAServer starts a server that publishes test certs with the names in IoTHubTLSRequirements, one PEM encoded
+// and one DER encoded as Microsoft publishes its roots. It returns their URLs and a count of the requests served.
+func newRootCAServer(t *testing.T) ([]string, *atomic.Int32) {
+	t.Helper()
+
+	names := IoTHubTLSRequirements().ServerRootCAs
+	pemCert, _ := newTestCert(t, names[0])
+	derCert, _ := newTestCert(t, names[1])
+	block, _ := pem.Decode(derCert)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/root.pem":
+			w.Write(pemCert)
+		case "/root.crt":
+			w.Write(block.Bytes)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return []string{srv.URL + "/root.pem", srv.URL + "/root.crt"}, &requests
+}
+
+func TestFetchAndCacheRootCAs(t *testing.T) {
+	urls, requests := newRootCAServer(t)
+	cachePath := filepath.Join(t.TempDir(), "roots.pem")
+
+	// Cache miss: the roots are downloaded and cached.
+	if _, err := fetchAndCacheRootCAs(context.Background(), nil, urls, cachePath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+	cached, err := os.ReadFile(cachePath)
+	if err != nil {
+		t.Fatalf("cache wasn't written: %v", err)
+	}
+	if _, n, err := LoadCACerts(bytes.NewReader(cached)); err != nil || n != 2 {
+		t.Errorf("got %d cached certs and error %v, want 2 certs", n, err)
+	}
+
+	// Cache hit: nothing is downloaded.
+	if _, err := fetchAndCacheRootCAs(context.Background(), nil, urls, cachePath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("got %d requests after a cache hit, want 2", got)
+	}
+}
+
+func TestFetchAndCacheRootCAsInvalidCache(t *testing.T) {
+	urls, requests := newRootCAServer(t)
+	other, _ := newTestCert(t, "Some Other Root")
+	cachePath := writeTestFile(t, "roots.pem", other)
+
+	if _, err := fetchAndCacheRootCAs(context.Background(), nil, urls, cachePath); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+	if cached, _ := os.ReadFile(cachePath); bytes.Equal(cached, other) {
+		t.Error("invalid cache wasn't replaced")
+	}
+}
+
+func TestFetchAndCacheRootCAsOffline(t *testing.T) {
+	urls, _ := newRootCAServer(t)
+	urls[1] += "-missing"
+	cachePath := filepath.Join(t.TempDir(), "roots.pem")
+
+	pool, err := fetchAndCacheRootCAs(context.Background(), nil, urls, cachePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	embedded, err := rootCAPool(embeddedRootCAs)
+	if err != nil {
+		t.Fatalf("embedded roots aren't valid: %v", err)
+	}
+	if !pool.Equal(embedded) {
+		t.Error("got a pool other than the embedded roots")
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Errorf("cache was written when the download failed: %v", err)
+	}
+}
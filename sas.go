@@ -0,0 +1,262 @@
+package iothub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultSASTokenTTL is how long the shared access signatures generated when connecting with a SharedAccessKey are
+// valid. See WithSASTokenTTL.
+const DefaultSASTokenTTL = time.Hour
+
+// GenerateSASToken returns a shared access signature for resourceURI, e.g. the value of Device.ResourceURI, signed
+// with key, which is base64-encoded as the Azure portal shows it, that expires at expiry.
+// See https://learn.microsoft.com/en-us/azure/iot-hub/authenticate-authorize-sas.
+func GenerateSASToken(resourceURI, key string, expiry time.Time) (string, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("iothub: shared access key is not valid base64: %v", err)
+	}
+
+	sr := url.QueryEscape(resourceURI)
+	se := strconv.FormatInt(expiry.Unix(), 10)
+
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte(sr + "\n" + se))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s", sr, url.QueryEscape(sig), se), nil
+}
+
+// SASToken returns a shared access signature for the device, or the module if ModuleID is set, signed with
+// SharedAccessKey, that's valid for ttl.
+func (d *Device) SASToken(ttl time.Duration) (string, error) {
+	if d.SharedAccessKey == "" {
+		return "", fmt.Errorf("iothub: device has no shared access key")
+	}
+
+	return GenerateSASToken(d.ResourceURI(), d.SharedAccessKey, now().Add(ttl))
+}
+
+// setSASCredentials makes the client authenticate with a shared access signature, valid for ttl, as its password. A
+// new signature is generated each time the client connects, so reconnects don't use an expired one.
+func (d *Device) setSASCredentials(opts *mqtt.ClientOptions, ttl time.Duration) error {
+	// Check the key up front, as the credentials provider can't return an error.
+	if _, err := d.SASToken(ttl); err != nil {
+		return err
+	}
+
+	username := opts.Username
+	opts.SetCredentialsProvider(func() (string, string) {
+		token, _ := d.SASToken(ttl)
+		return username, token
+	})
+	return nil
+}
+
+// WithSASTokenTTL returns an option that sets how long the shared access signatures with which the client
+// authenticates are valid, instead of DefaultSASTokenTTL. The client renews the signature before it expires as
+// described by NewClient. It's an error if the device doesn't have a SharedAccessKey or ttl isn't positive.
+func WithSASTokenTTL(ttl time.Duration) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		if d.SharedAccessKey == "" {
+			return fmt.Errorf("iothub: SAS token TTL given for a device without a shared access key")
+		}
+		if ttl <= 0 {
+			return fmt.Errorf("iothub: SAS token TTL must be positive, got %v", ttl)
+		}
+
+		return d.setSASCredentials(opts, ttl)
+	}
+}
+
+const (
+	// sasRenewalFraction is the fraction of a shared access signature's lifetime after which the client reconnects
+	// with a new one.
+	sasRenewalFraction = 0.9
+
+	// sasRenewalQuiesce is how long the client waits for in-flight work to complete when it disconnects to renew its
+	// shared access signature.
+	sasRenewalQuiesce = 250 * time.Millisecond
+
+	// sasRenewalRetry is how long the client keeps trying to reconnect after disconnecting to renew its shared access
+	// signature.
+	sasRenewalRetry = 5 * time.Minute
+)
+
+// sasAfterFunc is time.AfterFunc. It's a variable so that tests can see when renewals are scheduled.
+var sasAfterFunc = time.AfterFunc
+
+// WithSASRenewalLead returns an option that makes the client renew its shared access signature lead before the
+// signature expires, instead of after 90% of its lifetime as described by NewClient. lead must be positive and less
+// than the signature's TTL (see WithSASTokenTTL); NewClient returns an error otherwise. It's an error if the device
+// doesn't have a SharedAccessKey.
+func WithSASRenewalLead(lead time.Duration) func(*Device, *mqtt.ClientOptions) error {
+	return func(d *Device, opts *mqtt.ClientOptions) error {
+		if d.SharedAccessKey == "" {
+			return fmt.Errorf("iothub: SAS renewal lead given for a device without a shared access key")
+		}
+		if lead <= 0 {
+			return fmt.Errorf("iothub: SAS renewal lead must be positive, got %v", lead)
+		}
+
+		r, ok := pendingSASRenewals.get(opts)
+		if !ok {
+			return fmt.Errorf("iothub: SAS renewal lead may only be given to NewClient, NewClientForBroker, or BuildOptions")
+		}
+		r.lead = lead
+		return nil
+	}
+}
+
+// pendingSASRenewals records the sasRenewal of each ClientOptions that's being built, so that options such as
+// WithSASRenewalLead can configure it before it's installed by setSASRenewal.
+var pendingSASRenewals = sasRenewalRegistry{m: make(map[*mqtt.ClientOptions]*sasRenewal)}
+
+type sasRenewalRegistry struct {
+	sync.Mutex
+	m map[*mqtt.ClientOptions]*sasRenewal
+}
+
+func (reg *sasRenewalRegistry) add(opts *mqtt.ClientOptions, r *sasRenewal) {
+	reg.Lock()
+	defer reg.Unlock()
+	reg.m[opts] = r
+}
+
+func (reg *sasRenewalRegistry) get(opts *mqtt.ClientOptions) (*sasRenewal, bool) {
+	reg.Lock()
+	defer reg.Unlock()
+	r, ok := reg.m[opts]
+	return r, ok
+}
+
+func (reg *sasRenewalRegistry) remove(opts *mqtt.ClientOptions) {
+	reg.Lock()
+	defer reg.Unlock()
+	delete(reg.m, opts)
+}
+
+// sasRenewal reconnects a client before the shared access signature it connected with expires. IoT Hub closes the
+// connection when the signature expires, so renewing it ahead of time avoids an unplanned drop, and the messages
+// that would be lost with it, in the middle of the client's work.
+type sasRenewal struct {
+	d *Device
+	// lead, if nonzero, is how long before the signature expires it's renewed. Otherwise it's renewed after
+	// sasRenewalFraction of its lifetime.
+	lead time.Duration
+
+	mu sync.Mutex
+	// issued and expiry are the times at which the most recently generated signature was generated and expires.
+	issued, expiry time.Time
+	timer          *time.Timer
+	// gen is incremented on each connect so that a renewal scheduled for an earlier connection doesn't act.
+	gen int
+}
+
+// setSASRenewal makes the client renew its shared access signature as described by r. It wraps the options'
+// credentials provider and OnConnect handler, so it must be called after other options have been applied. It returns
+// an error if r's lead isn't less than the lifetime of the signatures that the credentials provider generates.
+func (d *Device) setSASRenewal(opts *mqtt.ClientOptions, r *sasRenewal) error {
+
+	provider := opts.CredentialsProvider
+	if r.lead > 0 {
+		_, password := provider()
+		if expiry, ok := sasTokenExpiry(password); ok && now().Add(r.lead).After(expiry) {
+			return fmt.Errorf("iothub: SAS renewal lead %v must be less than the SAS token TTL", r.lead)
+		}
+	}
+	opts.SetCredentialsProvider(func() (string, string) {
+		username, password := provider()
+		if expiry, ok := sasTokenExpiry(password); ok {
+			r.mu.Lock()
+			r.issued, r.expiry = now(), expiry
+			r.mu.Unlock()
+		}
+		return username, password
+	})
+
+	onConnect := opts.OnConnect
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		r.schedule(client)
+		if onConnect != nil {
+			onConnect(client)
+		}
+	})
+	return nil
+}
+
+// schedule arranges for the client, which has just connected, to be reconnected lead before its signature expires,
+// or when sasRenewalFraction of the signature's lifetime has passed if lead isn't set.
+func (r *sasRenewal) schedule(client mqtt.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.gen++
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	if r.expiry.IsZero() {
+		return
+	}
+
+	gen := r.gen
+	delay := time.Duration(float64(r.expiry.Sub(r.issued))*sasRenewalFraction) - now().Sub(r.issued)
+	if r.lead > 0 {
+		delay = r.expiry.Add(-r.lead).Sub(now())
+	}
+	r.timer = sasAfterFunc(delay, func() { r.renew(client, gen) })
+}
+
+// renew disconnects the client and connects it again, which generates a new signature, unless the client has since
+// disconnected or reconnected. Once it's reconnected, the subscriptions made by this package's helpers, e.g.
+// ServeMethods, are restored.
+func (r *sasRenewal) renew(client mqtt.Client, gen int) {
+	r.mu.Lock()
+	current := gen == r.gen
+	r.mu.Unlock()
+	if !current || !client.IsConnectionOpen() {
+		return
+	}
+
+	client.Disconnect(uint(sasRenewalQuiesce.Milliseconds()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), sasRenewalRetry)
+	defer cancel()
+	if err := r.d.ConnectWithRetry(ctx, client, time.Second, time.Minute); err != nil {
+		mqtt.ERROR.Printf("[iothub] failed to reconnect with a renewed shared access signature: %v", err)
+		return
+	}
+	if err := resubscribe(ctx, client); err != nil {
+		mqtt.ERROR.Printf("[iothub] %v after renewing the shared access signature", err)
+	}
+}
+
+// sasTokenExpiry returns the expiry of the shared access signature token. It reports whether token is a shared access
+// signature with an expiry.
+func sasTokenExpiry(token string) (time.Time, bool) {
+	fields, ok := strings.CutPrefix(token, "SharedAccessSignature ")
+	if !ok {
+		return time.Time{}, false
+	}
+
+	values, err := url.ParseQuery(fields)
+	if err != nil {
+		return time.Time{}, false
+	}
+	se, err := strconv.ParseInt(values.Get("se"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(se, 0), true
+}
@@ -0,0 +1,369 @@
+package iothub
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestGenerateSASToken(t *testing.T) {
+	got, err := GenerateSASToken("myhub.azure-devices.net/devices/foo", "c2VjcmV0", time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "SharedAccessSignature sr=myhub.azure-devices.net%2Fdevices%2Ffoo&sig=pV6uK5vKl7p9tPkFuwt1vn%2BL1WO5qBuUx9OvrSfw3VI%3D&se=1700000000"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGenerateSASTokenBadKey(t *testing.T) {
+	if _, err := GenerateSASToken("myhub.azure-devices.net/devices/foo", "not base64!", time.Unix(1700000000, 0)); err == nil {
+		t.Error("got nil error, want error")
+	}
+}
+
+func TestSASToken(t *testing.T) {
+	clock := time.Unix(1700000000, 0).Add(-time.Hour)
+	fakeNow(t, &clock)
+
+	d := Device{HubName: "myhub", DeviceID: "foo", SharedAccessKey: "c2VjcmV0"}
+	got, err := d.SASToken(time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, _ := GenerateSASToken(d.ResourceURI(), d.SharedAccessKey, time.Unix(1700000000, 0))
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := device.SASToken(time.Hour); err == nil {
+		t.Error("got nil error for a device without a shared access key, want error")
+	}
+}
+
+func TestNewClientSAS(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	fakeNow(t, &clock)
+	created := fakeNewMQTTClient(t)
+
+	d := newTestDevice(t)
+	d.CertPath, d.PrivKeyPath = "", ""
+	d.SharedAccessKey = "c2VjcmV0"
+	if err := d.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		options []func(*Device, *mqtt.ClientOptions) error
+		wantTTL time.Duration
+	}{
+		{"default TTL", nil, DefaultSASTokenTTL},
+		{"custom TTL", []func(*Device, *mqtt.ClientOptions) error{WithSASTokenTTL(time.Minute)}, time.Minute},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := d.NewClient(c.options...); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			opts := created[d.ClientID()]
+			if opts.CredentialsProvider == nil {
+				t.Fatal("no credentials provider was set")
+			}
+			if len(opts.TLSConfig.Certificates) > 0 || opts.TLSConfig.GetClientCertificate != nil {
+				t.Error("got a client cert for a device with a shared access key")
+			}
+
+			username, password := opts.CredentialsProvider()
+			if username != d.Username() {
+				t.Errorf("got username %q, want %q", username, d.Username())
+			}
+			want, _ := GenerateSASToken(d.ResourceURI(), d.SharedAccessKey, clock.Add(c.wantTTL))
+			if password != want {
+				t.Errorf("got password %q, want %q", password, want)
+			}
+
+			// Each connection gets a token that's valid from the time it's made.
+			clock = clock.Add(time.Hour)
+			_, password = opts.CredentialsProvider()
+			if want, _ := GenerateSASToken(d.ResourceURI(), d.SharedAccessKey, clock.Add(c.wantTTL)); password != want {
+				t.Errorf("after an hour got password %q, want %q", password, want)
+			}
+		})
+	}
+}
+
+func TestWithSASTokenTTLErrors(t *testing.T) {
+	fakeNewMQTTClient(t)
+
+	d := newTestDevice(t)
+	if _, err := d.NewClient(WithSASTokenTTL(time.Minute)); err == nil || !strings.Contains(err.Error(), "shared access key") {
+		t.Errorf("got error %v for a device without a shared access key, want error", err)
+	}
+
+	d.CertPath, d.PrivKeyPath = "", ""
+	d.SharedAccessKey = "c2VjcmV0"
+	if _, err := d.NewClient(WithSASTokenTTL(0)); err == nil {
+		t.Error("got nil error for a TTL of 0, want error")
+	}
+}
+
+func TestValidateSharedAccessKey(t *testing.T) {
+	cases := []struct {
+		name    string
+		modify  func(d *Device)
+		wantErr bool
+	}{
+		{"key only", func(d *Device) {}, false},
+		{"key and cert", func(d *Device) { d.CertPath = "foo.x509" }, true},
+		{"key and combined PEM", func(d *Device) { d.CombinedPEMPath = "foo-combined.pem" }, true},
+		{"bad key", func(d *Device) { d.SharedAccessKey = "not base64!" }, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := Device{HubName: "myhub", DeviceID: "foo", CACerts: "roots.pem", SharedAccessKey: "c2VjcmV0"}
+			c.modify(&d)
+			if err := d.Validate(); (err != nil) != c.wantErr {
+				t.Errorf("got error %v, want error: %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestSASTokenExpiry(t *testing.T) {
+	token, _ := GenerateSASToken("myhub.azure-devices.net/devices/foo", "c2VjcmV0", time.Unix(1700000000, 0))
+
+	cases := []struct {
+		token  string
+		want   time.Time
+		wantOK bool
+	}{
+		{token, time.Unix(1700000000, 0), true},
+		{"hunter2", time.Time{}, false},
+		{"SharedAccessSignature sr=foo&sig=bar", time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		got, ok := sasTokenExpiry(c.token)
+		if !got.Equal(c.want) || ok != c.wantOK {
+			t.Errorf("sasTokenExpiry(%q) = (%v, %v), want (%v, %v)", c.token, got, ok, c.want, c.wantOK)
+		}
+	}
+}
+
+func TestSASRenewal(t *testing.T) {
+	// The signature's expiry is truncated to a second, so with a TTL of a second and a clock 950ms past a second it
+	// expires 50ms from now and is renewed in 45ms.
+	clock := time.Unix(1700000000, 0).Add(950 * time.Millisecond)
+	fakeNow(t, &clock)
+	created := fakeNewMQTTClient(t)
+
+	d := Device{HubName: "myhub", DeviceID: "foo", SharedAccessKey: "c2VjcmV0"}
+	connected := make(chan struct{}, 1)
+	client, err := d.NewClientForBroker(MQTTBroker{Host: "localhost", Port: 1883}, nil,
+		WithSASTokenTTL(time.Second),
+		func(d *Device, opts *mqtt.ClientOptions) error {
+			opts.SetOnConnectHandler(func(mqtt.Client) { connected <- struct{}{} })
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts := created[d.ClientID()]
+	fc := client.(*fakeClient)
+
+	// Simulate paho connecting: it gets the credentials and then calls the OnConnect handler.
+	fc.Connect()
+	opts.CredentialsProvider()
+	opts.OnConnect(client)
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("the caller's OnConnect handler wasn't called")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fc.mu.Lock()
+		calls := strings.Join(fc.calls, ",")
+		fc.mu.Unlock()
+		if calls == "Connect,Disconnect,Connect" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got calls %s, want the client to reconnect", calls)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSASRenewalSkippedAfterDisconnect(t *testing.T) {
+	clock := time.Unix(1700000000, 0).Add(950 * time.Millisecond)
+	fakeNow(t, &clock)
+	created := fakeNewMQTTClient(t)
+
+	d := Device{HubName: "myhub", DeviceID: "foo", SharedAccessKey: "c2VjcmV0"}
+	client, err := d.NewClientForBroker(MQTTBroker{Host: "localhost", Port: 1883}, nil, WithSASTokenTTL(time.Second))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts := created[d.ClientID()]
+	fc := client.(*fakeClient)
+
+	fc.Connect()
+	opts.CredentialsProvider()
+	opts.OnConnect(client)
+	fc.Disconnect(0)
+
+	time.Sleep(200 * time.Millisecond)
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if calls := strings.Join(fc.calls, ","); calls != "Connect,Disconnect" {
+		t.Errorf("got calls %s, want no reconnect after the caller disconnected", calls)
+	}
+}
+
+func TestSASRenewalResubscribes(t *testing.T) {
+	clock := time.Unix(1700000000, 0).Add(950 * time.Millisecond)
+	fakeNow(t, &clock)
+	created := fakeNewMQTTClient(t)
+
+	d := Device{HubName: "myhub", DeviceID: "foo", SharedAccessKey: "c2VjcmV0"}
+	client, err := d.NewClientForBroker(MQTTBroker{Host: "localhost", Port: 1883}, nil, WithSASTokenTTL(time.Second))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts := created[d.ClientID()]
+	fc := client.(*fakeClient)
+	fc.cleanSession = true
+
+	fc.Connect()
+	opts.CredentialsProvider()
+	opts.OnConnect(client)
+
+	var r MethodRouter
+	r.Handle("reboot", func(payload []byte) (int, []byte) { return 200, nil })
+	if err := d.ServeMethods(client, &r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fc.mu.Lock()
+		calls := strings.Join(fc.calls, ",")
+		_, subscribed := fc.subscriptions[d.MethodRequestTopic()]
+		fc.mu.Unlock()
+		if calls == "Connect,Disconnect,Connect" && subscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got calls %s and method subscription %v, want the client to reconnect and resubscribe", calls, subscribed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fc.deliver("$iothub/methods/POST/reboot/?$rid=1", nil)
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if len(fc.published) != 1 || fc.published[0].topic != "$iothub/methods/res/200/?$rid=1" {
+		t.Errorf("got published messages %v, want a response to the method request", fc.published)
+	}
+}
+
+// fakeSASAfterFunc makes renewals be scheduled with a timer that never fires, and returns a function that reports the
+// delay with which the last renewal was scheduled.
+func fakeSASAfterFunc(t *testing.T) func() time.Duration {
+	t.Helper()
+	orig := sasAfterFunc
+	t.Cleanup(func() { sasAfterFunc = orig })
+
+	var mu sync.Mutex
+	var last time.Duration
+	sasAfterFunc = func(d time.Duration, f func()) *time.Timer {
+		mu.Lock()
+		defer mu.Unlock()
+		last = d
+		return time.NewTimer(time.Hour)
+	}
+	return func() time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return last
+	}
+}
+
+func TestSASRenewalSchedule(t *testing.T) {
+	cases := []struct {
+		name    string
+		options []func(*Device, *mqtt.ClientOptions) error
+		want    time.Duration
+	}{
+		{"default", nil, 54 * time.Minute},
+		{"lead", []func(*Device, *mqtt.ClientOptions) error{WithSASRenewalLead(10 * time.Minute)}, 50 * time.Minute},
+		{
+			"lead and TTL",
+			[]func(*Device, *mqtt.ClientOptions) error{WithSASRenewalLead(time.Minute), WithSASTokenTTL(2 * time.Hour)},
+			119 * time.Minute,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clock := time.Unix(1700000000, 0)
+			fakeNow(t, &clock)
+			created := fakeNewMQTTClient(t)
+			scheduled := fakeSASAfterFunc(t)
+
+			d := Device{HubName: "myhub", DeviceID: "foo", SharedAccessKey: "c2VjcmV0"}
+			client, err := d.NewClientForBroker(MQTTBroker{Host: "localhost", Port: 1883}, nil, c.options...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			opts := created[d.ClientID()]
+
+			// The renewal is scheduled relative to the signature's expiry, however long after it was generated the
+			// client connects.
+			opts.CredentialsProvider()
+			clock = clock.Add(time.Second)
+			client.(*fakeClient).Connect()
+			opts.OnConnect(client)
+
+			if got, want := scheduled(), c.want-time.Second; got != want {
+				t.Errorf("renewal scheduled in %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWithSASRenewalLeadErrors(t *testing.T) {
+	fakeNewMQTTClient(t)
+
+	cases := []struct {
+		name    string
+		d       Device
+		options []func(*Device, *mqtt.ClientOptions) error
+	}{
+		{"no key", newTestDevice(t), []func(*Device, *mqtt.ClientOptions) error{WithSASRenewalLead(time.Minute)}},
+		{"zero", Device{HubName: "myhub", DeviceID: "foo", SharedAccessKey: "c2VjcmV0"},
+			[]func(*Device, *mqtt.ClientOptions) error{WithSASRenewalLead(0)}},
+		{"not less than TTL", Device{HubName: "myhub", DeviceID: "foo", SharedAccessKey: "c2VjcmV0"},
+			[]func(*Device, *mqtt.ClientOptions) error{WithSASRenewalLead(time.Hour)}},
+		{"not less than custom TTL", Device{HubName: "myhub", DeviceID: "foo", SharedAccessKey: "c2VjcmV0"},
+			[]func(*Device, *mqtt.ClientOptions) error{WithSASRenewalLead(10 * time.Minute), WithSASTokenTTL(5 * time.Minute)}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := c.d.NewClientForBroker(MQTTBroker{Host: "localhost", Port: 1883}, nil, c.options...); err == nil {
+				t.Error("got nil error, want error")
+			}
+		})
+	}
+}
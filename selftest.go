@@ -0,0 +1,133 @@
+package iothub
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// dialTLS makes the TLS handshake for SelfTest. It's a variable so that tests can replace it.
+var dialTLS = func(ctx context.Context, addr string, conf *tls.Config) (net.Conn, error) {
+	dialer := &tls.Dialer{Config: conf}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// SelfTestStep is the outcome of one step of a self-test.
+type SelfTestStep struct {
+	// Ran is whether the step ran. Steps after a failed step don't run.
+	Ran      bool
+	Duration time.Duration
+	Err      error
+}
+
+func (s *SelfTestStep) run(fn func() error) error {
+	start := time.Now()
+	s.Err = fn()
+	s.Duration = time.Since(start)
+	s.Ran = true
+	return s.Err
+}
+
+// SelfTestReport describes the outcome of each step of a self-test.
+type SelfTestReport struct {
+	// TLSHandshake is a TLS handshake with the broker, made on a separate connection before connecting with MQTT.
+	TLSHandshake SelfTestStep
+	// Connect is connecting the MQTT client.
+	Connect SelfTestStep
+	// Publish is publishing a test telemetry message with QoS 1 and waiting for the broker to acknowledge it.
+	Publish SelfTestStep
+}
+
+// SelfTestPropertyKey is the key of the custom property set on the test telemetry message published by SelfTest.
+// Use it in message routing queries to keep test messages away from consumers that shouldn't see them.
+const SelfTestPropertyKey = "iothub-selftest"
+
+// SelfTest checks the device's connectivity end to end for field diagnostics: it makes a TLS handshake with the
+// broker, connects a client created with BuildOptions, publishes a test telemetry message, and disconnects. The
+// report records how long each step took and the error, if any. If a step fails, the later steps don't run, and
+// SelfTest returns the step's error as well as the report.
+//
+// caCerts is passed to BuildOptions. The test message's payload is empty and its SelfTestPropertyKey property is
+// "true".
+func (d *Device) SelfTest(ctx context.Context, caCerts io.Reader) (SelfTestReport, error) {
+	var report SelfTestReport
+
+	opts, err := d.BuildOptions(caCerts)
+	if err != nil {
+		return report, err
+	}
+
+	broker := d.Broker()
+	err = report.TLSHandshake.run(func() error {
+		conn, err := dialTLS(ctx, net.JoinHostPort(broker.Host, strconv.Itoa(broker.Port)), opts.TLSConfig)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+	if err != nil {
+		return report, fmt.Errorf("iothub: self-test TLS handshake failed: %w", err)
+	}
+
+	client := newMQTTClient(opts)
+	err = report.Connect.run(func() error {
+		return connectError(waitToken(ctx, client.Connect()))
+	})
+	if err != nil {
+		return report, fmt.Errorf("iothub: self-test connect failed: %w", err)
+	}
+	defer client.Disconnect(250)
+
+	err = report.Publish.run(func() error {
+		topic := d.TelemetryTopicWithProperties(TelemetryProperties{
+			Custom: map[string]string{SelfTestPropertyKey: "true"},
+		})
+		return waitToken(ctx, client.Publish(topic, 1, false, []byte{}))
+	})
+	if err != nil {
+		return report, fmt.Errorf("iothub: self-test publish failed: %w", err)
+	}
+
+	return report, nil
+}
+
+// VerifyBrokerCert makes a TLS handshake with the broker and checks that the cert it presents chains to one of the CA
+// certs read from caCerts, rather than to the system's roots. Use it to catch a misconfigured or outdated CA certs
+// file before relying on it. It returns a descriptive error if the cert doesn't verify.
+func (d *Device) VerifyBrokerCert(ctx context.Context, caCerts io.Reader) error {
+	broker := d.Broker()
+	return verifyBrokerCert(ctx, net.JoinHostPort(broker.Host, strconv.Itoa(broker.Port)), broker.Host, caCerts)
+}
+
+// verifyBrokerCert is like VerifyBrokerCert but connects to addr and verifies the cert for serverName.
+func verifyBrokerCert(ctx context.Context, addr, serverName string, caCerts io.Reader) error {
+	pool, _, err := LoadCACerts(caCerts)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialTLS(ctx, addr, &tls.Config{
+		RootCAs:    pool,
+		ServerName: serverName,
+		MinVersion: IoTHubTLSRequirements().MinVersion,
+	})
+	if err != nil {
+		var verifyErr *tls.CertificateVerificationError
+		if errors.As(err, &verifyErr) {
+			var unknown x509.UnknownAuthorityError
+			if errors.As(err, &unknown) {
+				return fmt.Errorf("iothub: broker %s presented a cert that doesn't chain to any of the given CA certs "+
+					"(issuer %q); the CA certs may be outdated: %w", serverName, unknown.Cert.Issuer.CommonName, err)
+			}
+			return fmt.Errorf("iothub: broker %s presented a cert that failed verification: %w", serverName, err)
+		}
+		return fmt.Errorf("iothub: TLS handshake with broker %s failed: %w", serverName, err)
+	}
+	return conn.Close()
+}
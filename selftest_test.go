@@ -0,0 +1,134 @@
+package iothub
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeSelfTestDeps replaces the network dependencies of SelfTest with fakes for the duration of the test. dialErr is
+// returned by the TLS dial.
+func fakeSelfTestDeps(t *testing.T, client *fakeClient, dialErr error) {
+	t.Helper()
+
+	origNewMQTTClient, origDialTLS := newMQTTClient, dialTLS
+	t.Cleanup(func() {
+		newMQTTClient, dialTLS = origNewMQTTClient, origDialTLS
+	})
+
+	newMQTTClient = func(opts *mqtt.ClientOptions) mqtt.Client {
+		return client
+	}
+	dialTLS = func(ctx context.Context, addr string, conf *tls.Config) (net.Conn, error) {
+		if addr != "myhub.azure-devices.net:8883" {
+			t.Errorf("got dial address %q, want %q", addr, "myhub.azure-devices.net:8883")
+		}
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		time.Sleep(time.Millisecond)
+		c, _ := net.Pipe()
+		return c, nil
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	client := &fakeClient{}
+	fakeSelfTestDeps(t, client, nil)
+
+	d := newTestDevice(t)
+	report, err := d.SelfTest(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	steps := map[string]SelfTestStep{
+		"TLSHandshake": report.TLSHandshake,
+		"Connect":      report.Connect,
+		"Publish":      report.Publish,
+	}
+	for name, step := range steps {
+		if !step.Ran {
+			t.Errorf("%s didn't run", name)
+		}
+		if step.Duration <= 0 {
+			t.Errorf("%s has duration %v, want > 0", name, step.Duration)
+		}
+		if step.Err != nil {
+			t.Errorf("%s has error %v", name, step.Err)
+		}
+	}
+
+	if len(client.published) != 1 {
+		t.Fatalf("got %d published messages, want 1", len(client.published))
+	}
+	if got, want := client.published[0].topic, "devices/foo/messages/events/iothub-selftest=true"; got != want {
+		t.Errorf("got topic %q, want %q", got, want)
+	}
+	if want := []string{"Connect", "Disconnect"}; !reflect.DeepEqual(client.calls, want) {
+		t.Errorf("got calls %v, want %v", client.calls, want)
+	}
+}
+
+func TestSelfTestHandshakeFails(t *testing.T) {
+	errHandshake := errors.New("x509: certificate signed by unknown authority")
+	client := &fakeClient{}
+	fakeSelfTestDeps(t, client, errHandshake)
+
+	d := newTestDevice(t)
+	report, err := d.SelfTest(context.Background(), nil)
+	if !errors.Is(err, errHandshake) {
+		t.Errorf("got error %v, want %v", err, errHandshake)
+	}
+
+	if report.TLSHandshake.Err != errHandshake {
+		t.Errorf("got TLS handshake error %v, want %v", report.TLSHandshake.Err, errHandshake)
+	}
+	if report.Connect.Ran || report.Publish.Ran {
+		t.Error("steps after the failed step ran")
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("got client calls %v, want none", client.calls)
+	}
+}
+
+func TestVerifyBrokerCert(t *testing.T) {
+	port, certPEM := newTLSListenerPEM(t, "myhub.azure-devices.net")
+	addr := net.JoinHostPort("127.0.0.1", port)
+	otherPEM, _ := newTestCert(t, "Other Root CA")
+
+	cases := []struct {
+		name       string
+		serverName string
+		caCerts    []byte
+		wantErr    string
+	}{
+		{"trusted", "myhub.azure-devices.net", certPEM, ""},
+		{"untrusted", "myhub.azure-devices.net", otherPEM, "doesn't chain"},
+		{"wrong host", "otherhub.azure-devices.net", certPEM, "failed verification"},
+		{"no CA certs", "myhub.azure-devices.net", nil, "no CA certs"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := verifyBrokerCert(context.Background(), addr, c.serverName, bytes.NewReader(c.caCerts))
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, c.wantErr)
+			}
+		})
+	}
+}
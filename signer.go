@@ -0,0 +1,40 @@
+package iothub
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// SignerCertificate returns a client cert whose private key operations are done by signer, for devices whose private
+// key is held in a hardware security module, secure element, or other store from which it can't be exported. Use the
+// result as Device.ClientCert. leaf is the device's cert, which must be for signer's public key, and intermediates
+// are the certs, if any, that chain it to a CA registered with the hub.
+//
+// The TLS handshake uses signer to sign with the key. An RSA signer must support PSS, which TLS 1.3 requires; if it
+// doesn't, restrict the cert's SupportedSignatureAlgorithms to PKCS #1 v1.5 schemes and the connection's MaxVersion
+// to TLS 1.2.
+func SignerCertificate(signer crypto.Signer, leaf *x509.Certificate, intermediates ...*x509.Certificate) (*tls.Certificate, error) {
+	if signer == nil || leaf == nil {
+		return nil, fmt.Errorf("iothub: signer and leaf cert are required")
+	}
+
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return nil, fmt.Errorf("iothub: unsupported signer public key type %T", signer.Public())
+	}
+	if !pub.Equal(leaf.PublicKey) {
+		return nil, fmt.Errorf("iothub: signer's public key doesn't match the leaf cert's")
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  signer,
+		Leaf:        leaf,
+	}
+	for _, c := range intermediates {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert, nil
+}
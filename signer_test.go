@@ -0,0 +1,109 @@
+package iothub
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// countingSigner is a crypto.Signer that counts its signatures, standing in for a key held in hardware. It isn't
+// an *ecdsa.PrivateKey, so crypto/tls can only use it through the crypto.Signer interface.
+type countingSigner struct {
+	key   crypto.Signer
+	signs atomic.Int32
+}
+
+func (s *countingSigner) Public() crypto.PublicKey { return s.key.Public() }
+
+func (s *countingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.signs.Add(1)
+	return s.key.Sign(rand, digest, opts)
+}
+
+func newTestLeaf(t *testing.T, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+
+	block, _ := pem.Decode(newTestCertForKey(t, key, "foo"))
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func TestSignerCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &countingSigner{key: key}
+	leaf := newTestLeaf(t, key)
+
+	cert, err := SignerCertificate(signer, leaf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	serverPEM, serverKeyPEM := newTestCert(t, "myhub.azure-devices.net", "myhub.azure-devices.net")
+	serverCert, err := tls.X509KeyPair(serverPEM, serverKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverPEM)
+
+	d := Device{HubName: "myhub", DeviceID: "foo", ClientCert: cert}
+	clientConf, err := d.newTLSConfig(roots)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clientConf.ServerName = "myhub.azure-devices.net"
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	server := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+
+	if err := tls.Client(clientConn, clientConf).Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+
+	if peer := server.ConnectionState().PeerCertificates; len(peer) != 1 || !peer[0].Equal(leaf) {
+		t.Error("server didn't get the leaf cert")
+	}
+	if signer.signs.Load() == 0 {
+		t.Error("the handshake didn't use the signer")
+	}
+}
+
+func TestSignerCertificateMismatch(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SignerCertificate(&countingSigner{key: other}, newTestLeaf(t, key)); err == nil {
+		t.Error("got nil error for a signer that doesn't match the leaf cert, want error")
+	}
+}
@@ -0,0 +1,121 @@
+package iothub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// subscription is a subscription made by this package on the caller's behalf.
+type subscription struct {
+	qos     byte
+	handler mqtt.MessageHandler
+}
+
+// subscriptions records, for each client, the subscriptions that this package has made on the caller's behalf (e.g.
+// to the twin response topic by GetTwin) so that Disconnect can unsubscribe from them and they can be restored when
+// the package reconnects the client.
+var subscriptions = struct {
+	sync.Mutex
+	m map[mqtt.Client]map[string]subscription
+}{m: make(map[mqtt.Client]map[string]subscription)}
+
+func trackSubscription(client mqtt.Client, qos byte, handler mqtt.MessageHandler, topics ...string) {
+	subscriptions.Lock()
+	defer subscriptions.Unlock()
+
+	if subscriptions.m[client] == nil {
+		subscriptions.m[client] = make(map[string]subscription)
+	}
+	for _, topic := range topics {
+		subscriptions.m[client][topic] = subscription{qos: qos, handler: handler}
+	}
+}
+
+// takeSubscriptions returns the topics recorded for the client, sorted, and forgets them.
+func takeSubscriptions(client mqtt.Client) []string {
+	subscriptions.Lock()
+	defer subscriptions.Unlock()
+
+	topics := make([]string, 0, len(subscriptions.m[client]))
+	for topic := range subscriptions.m[client] {
+		topics = append(topics, topic)
+	}
+	delete(subscriptions.m, client)
+
+	sort.Strings(topics)
+	return topics
+}
+
+// resubscribe makes the subscriptions recorded for the client again, with the same QoS and handlers. It's used after
+// the package reconnects a client, since the broker drops a client's subscriptions when it disconnects unless the
+// session is persistent.
+func resubscribe(ctx context.Context, client mqtt.Client) error {
+	subscriptions.Lock()
+	subs := make(map[string]subscription, len(subscriptions.m[client]))
+	for topic, sub := range subscriptions.m[client] {
+		subs[topic] = sub
+	}
+	subscriptions.Unlock()
+
+	topics := make([]string, 0, len(subs))
+	for topic := range subs {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		sub := subs[topic]
+		if err := waitToken(ctx, client.Subscribe(topic, sub.qos, sub.handler)); err != nil {
+			return fmt.Errorf("iothub: failed to resubscribe to %s: %w", topic, err)
+		}
+	}
+
+	return nil
+}
+
+// SubscribeAll subscribes to all of the topics on which a device receives messages: cloud-to-device messages, twin
+// responses, desired property updates, and direct method requests. It makes a single subscribe request, and all
+// messages are passed to handler. Modules can't receive cloud-to-device messages, so if ModuleID is set the command
+// topic is left out.
+//
+// Helpers such as GetTwin and ServeMethods subscribe with their own handlers, which replace handler for their topics.
+func (d *Device) SubscribeAll(client mqtt.Client, qos byte, handler mqtt.MessageHandler) error {
+	filters := map[string]byte{
+		d.TwinResponseTopic():      qos,
+		d.DesiredPropertiesTopic(): qos,
+		d.MethodRequestTopic():     qos,
+	}
+	if d.ModuleID == "" {
+		filters[d.CommandTopic()] = qos
+	}
+
+	token := client.SubscribeMultiple(filters, handler)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("iothub: failed to subscribe: %w", err)
+	}
+
+	topics := make([]string, 0, len(filters))
+	for topic := range filters {
+		topics = append(topics, topic)
+	}
+	trackSubscription(client, qos, handler, topics...)
+
+	return nil
+}
+
+// Disconnect unsubscribes from the topics that this package's helpers subscribed to using the client and then
+// disconnects the client, waiting up to quiesce for in-flight work to complete. Topics the caller subscribed to
+// directly with the client are left to the broker to clean up.
+func (d *Device) Disconnect(client mqtt.Client, quiesce time.Duration) {
+	if topics := takeSubscriptions(client); len(topics) > 0 && client.IsConnectionOpen() {
+		client.Unsubscribe(topics...).WaitTimeout(quiesce)
+	}
+
+	client.Disconnect(uint(quiesce.Milliseconds()))
+}
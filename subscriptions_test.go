@@ -0,0 +1,67 @@
+package iothub
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestDisconnect(t *testing.T) {
+	client := &fakeClient{
+		onPublish: twinResponder("$iothub/twin/GET/", 200, "{}"),
+	}
+	client.Connect()
+
+	if _, err := device.GetTwin(context.Background(), client); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := device.ServeMethods(client, &MethodRouter{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	device.Disconnect(client, 250*time.Millisecond)
+
+	wantUnsubscribed := []string{device.MethodRequestTopic(), device.TwinResponseTopic()}
+	if !reflect.DeepEqual(client.unsubscribed, wantUnsubscribed) {
+		t.Errorf("got unsubscribed %v, want %v", client.unsubscribed, wantUnsubscribed)
+	}
+
+	wantCalls := []string{"Connect", "Unsubscribe", "Disconnect"}
+	if !reflect.DeepEqual(client.calls, wantCalls) {
+		t.Errorf("got calls %v, want %v", client.calls, wantCalls)
+	}
+}
+
+func TestDisconnectNoSubscriptions(t *testing.T) {
+	client := &fakeClient{}
+	client.Connect()
+
+	device.Disconnect(client, 250*time.Millisecond)
+
+	wantCalls := []string{"Connect", "Disconnect"}
+	if !reflect.DeepEqual(client.calls, wantCalls) {
+		t.Errorf("got calls %v, want %v", client.calls, wantCalls)
+	}
+}
+
+func TestSubscribeAll(t *testing.T) {
+	client := &fakeClient{}
+	if err := device.SubscribeAll(client, 1, func(mqtt.Client, mqtt.Message) {}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []map[string]byte{
+		{
+			"devices/foo/messages/devicebound/#":      1,
+			"$iothub/twin/res/#":                      1,
+			"$iothub/twin/PATCH/properties/desired/#": 1,
+			"$iothub/methods/POST/#":                  1,
+		},
+	}
+	if !reflect.DeepEqual(client.subscribeMultipleFilters, want) {
+		t.Errorf("got filters %v, want %v", client.subscribeMultipleFilters, want)
+	}
+}
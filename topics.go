@@ -0,0 +1,149 @@
+package iothub
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Prefixes of the topics that IoT Hub uses for device twins and direct methods. The Device topic methods are built
+// from them, and they're useful for routing messages received through a shared handler.
+// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support.
+const (
+	TwinResponsePrefix           = "$iothub/twin/res/"
+	TwinGetPrefix                = "$iothub/twin/GET/"
+	TwinReportedPropertiesPrefix = "$iothub/twin/PATCH/properties/reported/"
+	DesiredPropertiesPrefix      = "$iothub/twin/PATCH/properties/desired/"
+	MethodRequestPrefix          = "$iothub/methods/POST/"
+	MethodResponsePrefix         = "$iothub/methods/res/"
+)
+
+// Parts of the per-device topics, which are of the form devices/{device ID}/messages/{suffix}, or
+// devices/{device ID}/modules/{module ID}/messages/{suffix} for a module.
+const (
+	devicesPrefix     = "devices/"
+	modulesInfix      = "/modules/"
+	telemetrySuffix   = "/messages/events/"
+	commandSuffix     = "/messages/devicebound/"
+	requestIDQuery    = "?$rid="
+	multiLevelPattern = "#"
+)
+
+// ParseRequestID returns the request ID from the $rid query parameter of a topic such as a twin response topic
+// ($iothub/twin/res/{status}/?$rid={request ID}) or a direct method request topic
+// ($iothub/methods/POST/{method name}/?$rid={request ID}). The value is URL-decoded. It returns an error if the
+// topic has no request ID.
+func ParseRequestID(topic string) (string, error) {
+	_, query, ok := strings.Cut(topic, "?")
+	if !ok {
+		return "", fmt.Errorf("iothub: no request ID in topic: %q", topic)
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("iothub: malformed query in topic %q: %v", topic, err)
+	}
+
+	rid := values.Get("$rid")
+	if rid == "" {
+		return "", fmt.Errorf("iothub: no request ID in topic: %q", topic)
+	}
+
+	return rid, nil
+}
+
+// ParseTwinResponseTopic returns the status and request ID from a response topic of the form
+// $iothub/twin/res/{status}/?$rid={request ID}. Direct method response topics, of the form
+// $iothub/methods/res/{status}/?$rid={request ID}, are also accepted.
+func ParseTwinResponseTopic(topic string) (status int, rid string, err error) {
+	var rest string
+	switch {
+	case strings.HasPrefix(topic, TwinResponsePrefix):
+		rest = strings.TrimPrefix(topic, TwinResponsePrefix)
+	case strings.HasPrefix(topic, MethodResponsePrefix):
+		rest = strings.TrimPrefix(topic, MethodResponsePrefix)
+	default:
+		return 0, "", fmt.Errorf("iothub: not a response topic: %q", topic)
+	}
+
+	statusStr, _, ok := strings.Cut(rest, "/?")
+	if !ok {
+		return 0, "", fmt.Errorf("iothub: malformed response topic: %q", topic)
+	}
+
+	status, err = strconv.Atoi(statusStr)
+	if err != nil {
+		return 0, "", fmt.Errorf("iothub: malformed status in response topic: %q", topic)
+	}
+
+	rid, err = ParseRequestID(topic)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return status, rid, nil
+}
+
+// parseDesiredPropertiesTopic returns the version from a topic of the form
+// $iothub/twin/PATCH/properties/desired/?$version={new version}. Other query parameters may come before or after
+// $version. It returns an error if the version is missing, appears more than once, or isn't a non-negative integer.
+func parseDesiredPropertiesTopic(topic string) (int, error) {
+	if !strings.HasPrefix(topic, DesiredPropertiesPrefix) {
+		return 0, fmt.Errorf("iothub: not a desired properties topic: %q", topic)
+	}
+
+	_, query, _ := strings.Cut(topic, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return 0, fmt.Errorf("iothub: malformed query in topic %q: %v", topic, err)
+	}
+
+	versions := values["$version"]
+	switch {
+	case len(versions) == 0:
+		return 0, fmt.Errorf("iothub: no version in desired properties topic: %q", topic)
+	case len(versions) > 1:
+		return 0, fmt.Errorf("iothub: more than one version in desired properties topic: %q", topic)
+	}
+
+	// ParseUint rather than Atoi so that signs are rejected.
+	version, err := strconv.ParseUint(versions[0], 10, 31)
+	if err != nil {
+		return 0, fmt.Errorf("iothub: malformed version in desired properties topic: %q", topic)
+	}
+
+	return int(version), nil
+}
+
+// TopicMatches reports whether the topic matches the pattern, which is an MQTT topic filter that may contain the
+// wildcards + (matches exactly one level) and # (matches any number of levels, including none, and must be last).
+// As in MQTT, a pattern that starts with a wildcard doesn't match topics that start with $, such as IoT Hub's twin
+// and direct method topics.
+//
+// It's useful for telling apart messages passed to a single handler, e.g. one given to SubscribeAll:
+//
+//	if iothub.TopicMatches(d.MethodRequestTopic(), msg.Topic()) {
+//		// Handle a direct method request.
+//	}
+func TopicMatches(pattern, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(pattern, "+") || strings.HasPrefix(pattern, "#")) {
+		return false
+	}
+
+	ps := strings.Split(pattern, "/")
+	ts := strings.Split(topic, "/")
+	for i, p := range ps {
+		if p == "#" {
+			return i == len(ps)-1
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if p != "+" && p != ts[i] {
+			return false
+		}
+	}
+
+	return len(ps) == len(ts)
+}
@@ -0,0 +1,184 @@
+package iothub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRequestID(t *testing.T) {
+	cases := []struct {
+		topic   string
+		want    string
+		wantErr bool
+	}{
+		{"$iothub/twin/res/200/?$rid=1", "1", false},
+		{"$iothub/twin/res/204/?$version=5&$rid=abc", "abc", false},
+		{"$iothub/methods/POST/reboot/?$rid=2f", "2f", false},
+		{"$iothub/twin/res/200/?$rid=a%2Fb", "a/b", false},
+		{"$iothub/twin/res/200/", "", true},
+		{"$iothub/twin/res/200/?$version=5", "", true},
+		{"$iothub/twin/res/200/?$rid=", "", true},
+		{"$iothub/twin/res/200/?$rid=%zz", "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.topic, func(t *testing.T) {
+			got, err := ParseRequestID(c.topic)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseTwinResponseTopic(t *testing.T) {
+	cases := []struct {
+		topic      string
+		wantStatus int
+		wantRID    string
+		wantErr    bool
+	}{
+		{"$iothub/twin/res/200/?$rid=1", 200, "1", false},
+		{"$iothub/twin/res/204/?$rid=abc&$version=5", 204, "abc", false},
+		{"$iothub/methods/res/404/?$rid=7", 404, "7", false},
+		{"$iothub/twin/res/200/", 0, "", true},
+		{"$iothub/twin/res/ok/?$rid=1", 0, "", true},
+		{"$iothub/twin/res/200/?$version=5", 0, "", true},
+		{"devices/foo/messages/devicebound/", 0, "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.topic, func(t *testing.T) {
+			status, rid, err := ParseTwinResponseTopic(c.topic)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, c.wantErr)
+			}
+			if status != c.wantStatus || rid != c.wantRID {
+				t.Errorf("got (%d, %q), want (%d, %q)", status, rid, c.wantStatus, c.wantRID)
+			}
+		})
+	}
+}
+
+func TestParseDesiredPropertiesTopic(t *testing.T) {
+	cases := []struct {
+		topic   string
+		want    int
+		wantErr bool
+	}{
+		{"$iothub/twin/PATCH/properties/desired/?$version=5", 5, false},
+		{"$iothub/twin/PATCH/properties/desired/?$version=0", 0, false},
+		{"$iothub/twin/PATCH/properties/desired/?$version=5&$rid=1", 5, false},
+		{"$iothub/twin/PATCH/properties/desired/?$rid=1&$version=12&foo=bar", 12, false},
+		{"$iothub/twin/PATCH/properties/desired/?%24version=7", 7, false},
+		{"$iothub/twin/PATCH/properties/desired/", 0, true},
+		{"$iothub/twin/PATCH/properties/desired/?$rid=1", 0, true},
+		{"$iothub/twin/PATCH/properties/desired/?$version=", 0, true},
+		{"$iothub/twin/PATCH/properties/desired/?$version=five", 0, true},
+		{"$iothub/twin/PATCH/properties/desired/?$version=-1", 0, true},
+		{"$iothub/twin/PATCH/properties/desired/?$version=+5", 0, true},
+		{"$iothub/twin/PATCH/properties/desired/?$version=5.0", 0, true},
+		{"$iothub/twin/PATCH/properties/desired/?$version=99999999999", 0, true},
+		{"$iothub/twin/PATCH/properties/desired/?$version=5&$version=6", 0, true},
+		{"$iothub/twin/PATCH/properties/desired/?$version=5&bad=%zz", 0, true},
+		{"$iothub/twin/res/200/?$version=5", 0, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.topic, func(t *testing.T) {
+			got, err := parseDesiredPropertiesTopic(c.topic)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("got %d, want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	cases := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/b/c", "a/b/d", false},
+		{"a/b/c", "a/b", false},
+		{"a/b", "a/b/c", false},
+
+		// Single-level wildcard.
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+", "a/", true},
+		{"+/+", "a/b", true},
+
+		// Multi-level wildcard.
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "b/c", false},
+		{"#", "a/b/c", true},
+		{"a/#/c", "a/b/c", false},
+
+		// Wildcards at the first level don't match topics starting with $.
+		{"#", "$iothub/twin/res/200/?$rid=1", false},
+		{"+/twin/res/#", "$iothub/twin/res/200/?$rid=1", false},
+
+		// IoT Hub device topics.
+		{device.CommandTopic(), "devices/foo/messages/devicebound/%24.to=%2Fdevices%2Ffoo%2Fmessages%2Fdevicebound", true},
+		{device.CommandTopic(), "devices/bar/messages/devicebound/", false},
+		{device.TwinResponseTopic(), "$iothub/twin/res/200/?$rid=1", true},
+		{device.TwinResponseTopic(), "$iothub/twin/PATCH/properties/desired/?$version=2", false},
+		{device.DesiredPropertiesTopic(), "$iothub/twin/PATCH/properties/desired/?$version=2", true},
+		{device.MethodRequestTopic(), "$iothub/methods/POST/reboot/?$rid=1", true},
+		{device.MethodRequestTopic(), "$iothub/twin/res/200/?$rid=1", false},
+	}
+
+	for _, c := range cases {
+		if got := TopicMatches(c.pattern, c.topic); got != c.want {
+			t.Errorf("TopicMatches(%q, %q) = %v, want %v", c.pattern, c.topic, got, c.want)
+		}
+	}
+}
+
+func TestTopicPrefixes(t *testing.T) {
+	// Topics as they appear in the IoT Hub documentation, paired with the prefix that should match them.
+	cases := []struct {
+		prefix string
+		topic  string
+	}{
+		{TwinResponsePrefix, "$iothub/twin/res/200/?$rid=1"},
+		{TwinGetPrefix, "$iothub/twin/GET/?$rid=1"},
+		{TwinReportedPropertiesPrefix, "$iothub/twin/PATCH/properties/reported/?$rid=1"},
+		{DesiredPropertiesPrefix, "$iothub/twin/PATCH/properties/desired/?$version=3"},
+		{MethodRequestPrefix, "$iothub/methods/POST/reboot/?$rid=1"},
+		{MethodResponsePrefix, "$iothub/methods/res/200/?$rid=1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.prefix, func(t *testing.T) {
+			if !strings.HasPrefix(c.topic, c.prefix) {
+				t.Errorf("prefix %q doesn't match documented topic %q", c.prefix, c.topic)
+			}
+		})
+	}
+
+	// Each prefix must match exactly one of the documented topics, or routing by prefix would be ambiguous.
+	for _, c := range cases {
+		n := 0
+		for _, other := range cases {
+			if strings.HasPrefix(other.topic, c.prefix) {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Errorf("prefix %q matches %d documented topics, want 1", c.prefix, n)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package iothub
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// TPMSigner returns a crypto.Signer for the RSA or ECC signing key at handle in the TPM, typically a persistent
+// device identity key, e.g. at 0x81000002. The key must not require authorization (its auth value must be empty), as
+// is usual for keys used for TLS. Signing is done by the TPM; the private key never leaves it.
+//
+// Open tpm with github.com/google/go-tpm/tpm2/transport, e.g. transport.OpenTPM("/dev/tpmrm0"), and keep it open for
+// as long as the signer is used. The signer serializes its use of tpm, but other users of tpm must not send commands
+// concurrently with it.
+//
+// RSA keys sign with PSS when asked to, as TLS 1.3 requires. Some TPMs use a PSS salt length that TLS doesn't accept,
+// in which case limit the connection to TLS 1.2 with PKCS #1 v1.5 signatures.
+func TPMSigner(tpm transport.TPM, handle tpm2.TPMHandle) (crypto.Signer, error) {
+	rsp, err := tpm2.ReadPublic{ObjectHandle: handle}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("iothub: failed to read TPM key 0x%x: %w", uint32(handle), err)
+	}
+	pub, err := tpmPublicKey(&rsp.OutPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tpmSigner{tpm: tpm, handle: handle, name: rsp.Name, pub: pub}, nil
+}
+
+// TPMCertificate returns a client cert, for use as Device.ClientCert, whose private key is the TPM key at handle. See
+// TPMSigner and SignerCertificate.
+func TPMCertificate(tpm transport.TPM, handle tpm2.TPMHandle, leaf *x509.Certificate, intermediates ...*x509.Certificate) (*tls.Certificate, error) {
+	signer, err := TPMSigner(tpm, handle)
+	if err != nil {
+		return nil, err
+	}
+
+	return SignerCertificate(signer, leaf, intermediates...)
+}
+
+// EndorsementKey returns the public part of the TPM's RSA endorsement key, created from the standard template (TCG
+// EK Credential Profile, template L-1), both as a public key and in its TPM2B_PUBLIC encoding. The latter, base64-
+// encoded, is the form in which the Azure IoT Hub Device Provisioning Service takes the endorsement key of a TPM
+// enrollment. The endorsement hierarchy must not require authorization.
+// See https://learn.microsoft.com/en-us/azure/iot-dps/concepts-tpm-attestation.
+func EndorsementKey(tpm transport.TPM) (crypto.PublicKey, []byte, error) {
+	rsp, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.AuthHandle{
+			Handle: tpm2.TPMRHEndorsement,
+			Auth:   tpm2.PasswordAuth(nil),
+		},
+		InPublic: tpm2.New2B(tpm2.RSAEKTemplate),
+	}.Execute(tpm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("iothub: failed to create TPM endorsement key: %w", err)
+	}
+	defer tpm2.FlushContext{FlushHandle: rsp.ObjectHandle}.Execute(tpm)
+
+	pub, err := tpmPublicKey(&rsp.OutPublic)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pub, tpm2.Marshal(rsp.OutPublic), nil
+}
+
+// tpmPublicKey returns the public key in pub, which must be an RSA or ECC key.
+func tpmPublicKey(pub *tpm2.TPM2BPublic) (crypto.PublicKey, error) {
+	contents, err := pub.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("iothub: malformed TPM public area: %w", err)
+	}
+
+	switch contents.Type {
+	case tpm2.TPMAlgRSA:
+		parms, err := contents.Parameters.RSADetail()
+		if err != nil {
+			return nil, fmt.Errorf("iothub: malformed TPM RSA key: %w", err)
+		}
+		unique, err := contents.Unique.RSA()
+		if err != nil {
+			return nil, fmt.Errorf("iothub: malformed TPM RSA key: %w", err)
+		}
+		return tpm2.RSAPub(parms, unique)
+	case tpm2.TPMAlgECC:
+		parms, err := contents.Parameters.ECCDetail()
+		if err != nil {
+			return nil, fmt.Errorf("iothub: malformed TPM ECC key: %w", err)
+		}
+		unique, err := contents.Unique.ECC()
+		if err != nil {
+			return nil, fmt.Errorf("iothub: malformed TPM ECC key: %w", err)
+		}
+		p, err := tpm2.ECCPub(parms, unique)
+		if err != nil {
+			return nil, fmt.Errorf("iothub: unsupported TPM ECC key: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: p.Curve, X: p.X, Y: p.Y}, nil
+	}
+	return nil, fmt.Errorf("iothub: unsupported TPM key type 0x%x", uint16(contents.Type))
+}
+
+// tpmSigner is a crypto.Signer for a key in a TPM. It's safe for concurrent use.
+type tpmSigner struct {
+	mu     sync.Mutex
+	tpm    transport.TPM
+	handle tpm2.TPMHandle
+	name   tpm2.TPM2BName
+	pub    crypto.PublicKey
+}
+
+func (s *tpmSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs digest with the TPM key. rand is ignored; the TPM uses its own random number generator.
+func (s *tpmSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hashAlg, err := tpmHashAlg(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+
+	var sigAlg tpm2.TPMAlgID
+	switch s.pub.(type) {
+	case *ecdsa.PublicKey:
+		sigAlg = tpm2.TPMAlgECDSA
+	case *rsa.PublicKey:
+		sigAlg = tpm2.TPMAlgRSASSA
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			sigAlg = tpm2.TPMAlgRSAPSS
+		}
+	}
+
+	s.mu.Lock()
+	rsp, err := tpm2.Sign{
+		KeyHandle: tpm2.AuthHandle{
+			Handle: s.handle,
+			Name:   s.name,
+			Auth:   tpm2.PasswordAuth(nil),
+		},
+		Digest: tpm2.TPM2BDigest{Buffer: digest},
+		InScheme: tpm2.TPMTSigScheme{
+			Scheme:  sigAlg,
+			Details: tpm2.NewTPMUSigScheme(sigAlg, &tpm2.TPMSSchemeHash{HashAlg: hashAlg}),
+		},
+		Validation: tpm2.TPMTTKHashCheck{
+			Tag:       tpm2.TPMSTHashCheck,
+			Hierarchy: tpm2.TPMRHNull,
+		},
+	}.Execute(s.tpm)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("iothub: TPM failed to sign: %w", err)
+	}
+
+	switch sigAlg {
+	case tpm2.TPMAlgECDSA:
+		sig, err := rsp.Signature.Signature.ECDSA()
+		if err != nil {
+			return nil, fmt.Errorf("iothub: malformed TPM signature: %w", err)
+		}
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sig.SignatureR.Buffer),
+			new(big.Int).SetBytes(sig.SignatureS.Buffer),
+		})
+	case tpm2.TPMAlgRSAPSS:
+		sig, err := rsp.Signature.Signature.RSAPSS()
+		if err != nil {
+			return nil, fmt.Errorf("iothub: malformed TPM signature: %w", err)
+		}
+		return sig.Sig.Buffer, nil
+	default:
+		sig, err := rsp.Signature.Signature.RSASSA()
+		if err != nil {
+			return nil, fmt.Errorf("iothub: malformed TPM signature: %w", err)
+		}
+		return sig.Sig.Buffer, nil
+	}
+}
+
+// tpmHashAlg returns the TPM algorithm ID of the hash function h.
+func tpmHashAlg(h crypto.Hash) (tpm2.TPMAlgID, error) {
+	switch h {
+	case crypto.SHA1:
+		return tpm2.TPMAlgSHA1, nil
+	case crypto.SHA256:
+		return tpm2.TPMAlgSHA256, nil
+	case crypto.SHA384:
+		return tpm2.TPMAlgSHA384, nil
+	case crypto.SHA512:
+		return tpm2.TPMAlgSHA512, nil
+	}
+	return 0, fmt.Errorf("iothub: hash function %v is not supported by the TPM signer", h)
+}
@@ -0,0 +1,287 @@
+package iothub
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// fakeTPM is a transport.TPM that holds one key, in software, at a persistent handle and answers the commands used by
+// TPMSigner and EndorsementKey.
+type fakeTPM struct {
+	t      *testing.T
+	handle tpm2.TPMHandle
+	key    crypto.Signer
+	ek     *rsa.PrivateKey
+
+	flushed []tpm2.TPMHandle
+}
+
+// fakeEKHandle is the transient handle at which fakeTPM creates the endorsement key.
+const fakeEKHandle = tpm2.TPMHandle(0x80000001)
+
+// tpmPublicArea returns the TPM public area for pub.
+func tpmPublicArea(pub crypto.PublicKey) tpm2.TPM2BPublic {
+	noSym := tpm2.TPMTSymDefObject{Algorithm: tpm2.TPMAlgNull}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return tpm2.New2B(tpm2.TPMTPublic{
+			Type:    tpm2.TPMAlgECC,
+			NameAlg: tpm2.TPMAlgSHA256,
+			Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC, &tpm2.TPMSECCParms{
+				Symmetric: noSym,
+				Scheme:    tpm2.TPMTECCScheme{Scheme: tpm2.TPMAlgNull},
+				CurveID:   tpm2.TPMECCNistP256,
+				KDF:       tpm2.TPMTKDFScheme{Scheme: tpm2.TPMAlgNull},
+			}),
+			Unique: tpm2.NewTPMUPublicID(tpm2.TPMAlgECC, &tpm2.TPMSECCPoint{
+				X: tpm2.TPM2BECCParameter{Buffer: pub.X.FillBytes(make([]byte, 32))},
+				Y: tpm2.TPM2BECCParameter{Buffer: pub.Y.FillBytes(make([]byte, 32))},
+			}),
+		})
+	case *rsa.PublicKey:
+		return tpm2.New2B(tpm2.TPMTPublic{
+			Type:    tpm2.TPMAlgRSA,
+			NameAlg: tpm2.TPMAlgSHA256,
+			Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, &tpm2.TPMSRSAParms{
+				Symmetric: noSym,
+				Scheme:    tpm2.TPMTRSAScheme{Scheme: tpm2.TPMAlgNull},
+				KeyBits:   tpm2.TPMIRSAKeyBits(pub.N.BitLen()),
+			}),
+			Unique: tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{Buffer: pub.N.Bytes()}),
+		})
+	}
+	panic(fmt.Sprintf("unsupported key type %T", pub))
+}
+
+// tpmResponse returns a TPM response with the given tag, handle (if nonzero), and parameters. Responses to commands
+// with sessions get a parameter size and a password session's response.
+func tpmResponse(tag tpm2.TPMST, handle tpm2.TPMHandle, params ...[]byte) []byte {
+	var body []byte
+	if handle != 0 {
+		body = binary.BigEndian.AppendUint32(body, uint32(handle))
+	}
+	p := bytes.Join(params, nil)
+	if tag == tpm2.TPMSTSessions {
+		body = binary.BigEndian.AppendUint32(body, uint32(len(p)))
+	}
+	body = append(body, p...)
+	if tag == tpm2.TPMSTSessions {
+		// Empty nonce, continueSession, empty HMAC.
+		body = append(body, 0, 0, 1, 0, 0)
+	}
+
+	rsp := binary.BigEndian.AppendUint16(nil, uint16(tag))
+	rsp = binary.BigEndian.AppendUint32(rsp, uint32(10+len(body)))
+	rsp = binary.BigEndian.AppendUint32(rsp, 0)
+	return append(rsp, body...)
+}
+
+func (f *fakeTPM) Send(cmd []byte) ([]byte, error) {
+	cc := tpm2.TPMCC(binary.BigEndian.Uint32(cmd[6:10]))
+	empty2B := []byte{0, 0}
+
+	switch cc {
+	case tpm2.TPMCCReadPublic:
+		if h := tpm2.TPMHandle(binary.BigEndian.Uint32(cmd[10:14])); h != f.handle {
+			f.t.Errorf("ReadPublic of handle 0x%x, want 0x%x", uint32(h), uint32(f.handle))
+		}
+		public := tpmPublicArea(f.key.Public())
+		contents, err := public.Contents()
+		if err != nil {
+			return nil, err
+		}
+		name, err := tpm2.ObjectName(contents)
+		if err != nil {
+			return nil, err
+		}
+		return tpmResponse(tpm2.TPMSTNoSessions, 0, tpm2.Marshal(public), tpm2.Marshal(name), empty2B), nil
+
+	case tpm2.TPMCCSign:
+		// Skip the header and key handle, then the authorization area.
+		rest := cmd[14:]
+		authSize := binary.BigEndian.Uint32(rest)
+		rest = rest[4+authSize:]
+		n := binary.BigEndian.Uint16(rest)
+		digest := rest[2 : 2+n]
+		rest = rest[2+n:]
+		scheme := tpm2.TPMAlgID(binary.BigEndian.Uint16(rest))
+
+		var sig tpm2.TPMTSignature
+		switch scheme {
+		case tpm2.TPMAlgECDSA:
+			r, s, err := ecdsa.Sign(rand.Reader, f.key.(*ecdsa.PrivateKey), digest)
+			if err != nil {
+				return nil, err
+			}
+			sig = tpm2.TPMTSignature{
+				SigAlg: scheme,
+				Signature: tpm2.NewTPMUSignature(scheme, &tpm2.TPMSSignatureECC{
+					Hash:       tpm2.TPMAlgSHA256,
+					SignatureR: tpm2.TPM2BECCParameter{Buffer: r.Bytes()},
+					SignatureS: tpm2.TPM2BECCParameter{Buffer: s.Bytes()},
+				}),
+			}
+		case tpm2.TPMAlgRSASSA, tpm2.TPMAlgRSAPSS:
+			var opts crypto.SignerOpts = crypto.SHA256
+			if scheme == tpm2.TPMAlgRSAPSS {
+				opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+			}
+			s, err := f.key.Sign(rand.Reader, digest, opts)
+			if err != nil {
+				return nil, err
+			}
+			sig = tpm2.TPMTSignature{
+				SigAlg: scheme,
+				Signature: tpm2.NewTPMUSignature(scheme, &tpm2.TPMSSignatureRSA{
+					Hash: tpm2.TPMAlgSHA256,
+					Sig:  tpm2.TPM2BPublicKeyRSA{Buffer: s},
+				}),
+			}
+		default:
+			f.t.Fatalf("unexpected signing scheme 0x%x", uint16(scheme))
+		}
+		return tpmResponse(tpm2.TPMSTSessions, 0, tpm2.Marshal(sig)), nil
+
+	case tpm2.TPMCCCreatePrimary:
+		if h := tpm2.TPMHandle(binary.BigEndian.Uint32(cmd[10:14])); h != tpm2.TPMRHEndorsement {
+			f.t.Errorf("CreatePrimary in hierarchy 0x%x, want the endorsement hierarchy", uint32(h))
+		}
+		ticket := tpm2.TPMTTKCreation{Tag: tpm2.TPMSTCreation, Hierarchy: tpm2.TPMRHEndorsement}
+		return tpmResponse(tpm2.TPMSTSessions, fakeEKHandle,
+			tpm2.Marshal(tpmPublicArea(&f.ek.PublicKey)), empty2B, empty2B, tpm2.Marshal(ticket), empty2B), nil
+
+	case tpm2.TPMCCFlushContext:
+		f.flushed = append(f.flushed, tpm2.TPMHandle(binary.BigEndian.Uint32(cmd[10:14])))
+		return tpmResponse(tpm2.TPMSTNoSessions, 0), nil
+	}
+
+	f.t.Fatalf("unexpected TPM command 0x%x", uint32(cc))
+	return nil, nil
+}
+
+func TestTPMSigner(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("hello"))
+	cases := []struct {
+		name   string
+		key    crypto.Signer
+		opts   crypto.SignerOpts
+		verify func(sig []byte) error
+	}{
+		{"ECDSA", ecKey, crypto.SHA256, func(sig []byte) error {
+			if !ecdsa.VerifyASN1(&ecKey.PublicKey, digest[:], sig) {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		}},
+		{"RSA PKCS #1 v1.5", rsaKey, crypto.SHA256, func(sig []byte) error {
+			return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig)
+		}},
+		{"RSA PSS", rsaKey, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, func(sig []byte) error {
+			return rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig, nil)
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tpm := &fakeTPM{t: t, handle: 0x81000002, key: c.key}
+			signer, err := TPMSigner(tpm, 0x81000002)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+			if !ok || !pub.Equal(c.key.Public()) {
+				t.Errorf("got public key %v, want %v", signer.Public(), c.key.Public())
+			}
+
+			sig, err := signer.Sign(rand.Reader, digest[:], c.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := c.verify(sig); err != nil {
+				t.Errorf("signature doesn't verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestTPMSignerUnsupportedHash(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := TPMSigner(&fakeTPM{t: t, handle: 0x81000002, key: key}, 0x81000002)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := signer.Sign(rand.Reader, make([]byte, 16), crypto.MD5); err == nil {
+		t.Error("got nil error for MD5, want error")
+	}
+}
+
+func TestTPMCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := newTestLeaf(t, key)
+
+	cert, err := TPMCertificate(&fakeTPM{t: t, handle: 0x81000002, key: key}, 0x81000002, leaf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert.Leaf != leaf {
+		t.Error("cert doesn't have the leaf cert")
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TPMCertificate(&fakeTPM{t: t, handle: 0x81000002, key: other}, 0x81000002, leaf); err == nil {
+		t.Error("got nil error for a TPM key that doesn't match the leaf cert, want error")
+	}
+}
+
+func TestEndorsementKey(t *testing.T) {
+	ek, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpm := &fakeTPM{t: t, ek: ek}
+
+	pub, public, err := EndorsementKey(tpm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ek.PublicKey.Equal(pub) {
+		t.Errorf("got public key %v, want %v", pub, &ek.PublicKey)
+	}
+	if want := tpm2.Marshal(tpmPublicArea(&ek.PublicKey)); !bytes.Equal(public, want) {
+		t.Errorf("got TPM2B_PUBLIC %x, want %x", public, want)
+	}
+	if len(tpm.flushed) != 1 || tpm.flushed[0] != fakeEKHandle {
+		t.Errorf("got flushed handles %v, want [0x%x]", tpm.flushed, uint32(fakeEKHandle))
+	}
+}
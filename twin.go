@@ -0,0 +1,233 @@
+package iothub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type twinResponse struct {
+	status int
+	body   []byte
+}
+
+// pendingTwinRequests holds a channel for each outstanding twin request, keyed by request ID. All twin requests
+// subscribe to the twin response topic with the same handler, handleTwinResponse, which uses this map to route each
+// response to the request that's waiting for it. Sharing a handler means concurrent requests don't clobber each
+// other's subscriptions.
+var pendingTwinRequests = struct {
+	sync.Mutex
+	m map[string]chan twinResponse
+}{m: make(map[string]chan twinResponse)}
+
+func handleTwinResponse(client mqtt.Client, msg mqtt.Message) {
+	status, rid, err := ParseTwinResponseTopic(msg.Topic())
+	if err != nil {
+		return
+	}
+
+	pendingTwinRequests.Lock()
+	ch, ok := pendingTwinRequests.m[rid]
+	pendingTwinRequests.Unlock()
+	if !ok {
+		return
+	}
+
+	// The channel is buffered and only ever receives one response, so a redelivered response is dropped here. Once
+	// the request completes its ID is removed from pendingTwinRequests and any later redelivery is dropped above.
+	select {
+	case ch <- twinResponse{status: status, body: msg.Payload()}:
+	default:
+	}
+}
+
+// waitToken waits for the token to complete or for the context to be done, whichever happens first.
+func waitToken(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// twinRequest publishes payload to the topic returned by topicFn for a newly-generated request ID and waits for the
+// response with the matching request ID.
+func (d *Device) twinRequest(ctx context.Context, client mqtt.Client, topicFn func(requestID string) string, payload []byte) (twinResponse, error) {
+	rid := NewRequestID()
+	ch := make(chan twinResponse, 1)
+
+	pendingTwinRequests.Lock()
+	pendingTwinRequests.m[rid] = ch
+	pendingTwinRequests.Unlock()
+	defer func() {
+		pendingTwinRequests.Lock()
+		delete(pendingTwinRequests.m, rid)
+		pendingTwinRequests.Unlock()
+	}()
+
+	if err := waitToken(ctx, client.Subscribe(d.TwinResponseTopic(), 0, handleTwinResponse)); err != nil {
+		return twinResponse{}, fmt.Errorf("iothub: failed to subscribe to twin responses: %w", err)
+	}
+	trackSubscription(client, 0, handleTwinResponse, d.TwinResponseTopic())
+
+	if err := waitToken(ctx, client.Publish(topicFn(rid), 0, false, payload)); err != nil {
+		return twinResponse{}, fmt.Errorf("iothub: failed to publish twin request: %w", err)
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		return twinResponse{}, fmt.Errorf("iothub: no response to twin request %v: %w", rid, ctx.Err())
+	}
+}
+
+// GetTwin requests the device's twin and returns the twin document, which is JSON. It subscribes to the twin response
+// topic, publishes a GET request, and waits for the response that matches the request. The context controls how long
+// to wait. An error is returned if the response has a non-2xx status.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#retrieving-a-device-twins-properties.
+func (d *Device) GetTwin(ctx context.Context, client mqtt.Client) ([]byte, error) {
+	resp, err := d.twinRequest(ctx, client, d.TwinGetTopic, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.status < 200 || resp.status > 299 {
+		return nil, fmt.Errorf("iothub: twin GET failed with status %d: %s", resp.status, resp.body)
+	}
+
+	return resp.body, nil
+}
+
+// UpdateReportedProperties sends a patch of the device's reported properties and waits for IoT Hub to acknowledge
+// it. The patch is a JSON document containing the properties to update; properties set to null are deleted. The
+// context controls how long to wait. An error is returned unless the response has status 204.
+//
+// IoT Hub's MQTT API doesn't document a way to make the update conditional on the reported properties' version, so
+// concurrent updates of the same property are applied in the order IoT Hub receives them.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#update-device-twins-reported-properties.
+func (d *Device) UpdateReportedProperties(ctx context.Context, client mqtt.Client, patch []byte) error {
+	resp, err := d.twinRequest(ctx, client, d.TwinReportedPropertiesTopic, patch)
+	if err != nil {
+		return err
+	}
+
+	if resp.status != 204 {
+		return fmt.Errorf("iothub: reported properties update failed with status %d: %s", resp.status, resp.body)
+	}
+
+	return nil
+}
+
+// OnDesiredPropertiesChange subscribes to updates to the device twin's desired properties. For each update fn is
+// called with the new version of the desired properties and the patch, a JSON document containing the properties that
+// changed. Updates whose topic can't be parsed are ignored.
+//
+// fn is called from the MQTT client's message handler, so the same restrictions apply: it must not block.
+// For more information see https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support#receiving-desired-properties-update-notifications.
+func (d *Device) OnDesiredPropertiesChange(client mqtt.Client, fn func(version int, patch []byte)) error {
+	handler := func(client mqtt.Client, msg mqtt.Message) {
+		version, err := parseDesiredPropertiesTopic(msg.Topic())
+		if err != nil {
+			return
+		}
+
+		fn(version, msg.Payload())
+	}
+
+	token := client.Subscribe(d.DesiredPropertiesTopic(), 0, handler)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("iothub: failed to subscribe to desired property updates: %w", err)
+	}
+	trackSubscription(client, 0, handler, d.DesiredPropertiesTopic())
+
+	return nil
+}
+
+// GetTwinTyped is like Device.GetTwin but decodes the desired properties section of the twin document into a T, e.g.
+// a struct with fields for the properties the device uses. Properties that T doesn't have are ignored.
+func GetTwinTyped[T any](ctx context.Context, d *Device, client mqtt.Client) (T, error) {
+	var desired T
+	body, err := d.GetTwin(ctx, client)
+	if err != nil {
+		return desired, err
+	}
+
+	var twin struct {
+		Desired json.RawMessage `json:"desired"`
+	}
+	if err := json.Unmarshal(body, &twin); err != nil {
+		return desired, fmt.Errorf("iothub: malformed twin document: %w", err)
+	}
+	if len(twin.Desired) > 0 {
+		if err := json.Unmarshal(twin.Desired, &desired); err != nil {
+			return desired, fmt.Errorf("iothub: failed to decode desired properties: %w", err)
+		}
+	}
+
+	return desired, nil
+}
+
+// OnDesiredPropertiesChangeTyped is like Device.OnDesiredPropertiesChange but decodes each patch into a T. A patch
+// holds only the properties that changed, so fields of T for properties that didn't change are left at their zero
+// values. Patches that can't be decoded are logged to paho's ERROR logger (see EnablePahoLogging) and ignored.
+func OnDesiredPropertiesChangeTyped[T any](d *Device, client mqtt.Client, fn func(version int, patch T)) error {
+	return d.OnDesiredPropertiesChange(client, func(version int, patch []byte) {
+		var typed T
+		if err := json.Unmarshal(patch, &typed); err != nil {
+			mqtt.ERROR.Printf("[iothub] ignoring desired properties update %d: failed to decode patch: %v", version, err)
+			return
+		}
+		fn(version, typed)
+	})
+}
+
+// TwinPatch returns the patch that turns old into new, for sending to UpdateReportedProperties after marshaling to
+// JSON. Properties that are added or changed in new are set to their new values, properties missing from new are set
+// to nil (JSON null), which deletes them, and nested objects are diffed recursively. Properties whose names start
+// with $, such as $version and $metadata, are metadata that can't be patched and are ignored. If old and new are the
+// same the patch is empty.
+// See https://learn.microsoft.com/en-us/azure/iot-hub/iot-hub-devguide-device-twins#back-end-operations.
+func TwinPatch(old, new map[string]any) map[string]any {
+	patch := make(map[string]any)
+
+	for k, newV := range new {
+		if strings.HasPrefix(k, "$") {
+			continue
+		}
+
+		oldV, ok := old[k]
+		if !ok {
+			patch[k] = newV
+			continue
+		}
+
+		oldM, oldIsMap := oldV.(map[string]any)
+		newM, newIsMap := newV.(map[string]any)
+		if oldIsMap && newIsMap {
+			if p := TwinPatch(oldM, newM); len(p) > 0 {
+				patch[k] = p
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(oldV, newV) {
+			patch[k] = newV
+		}
+	}
+
+	for k := range old {
+		if _, ok := new[k]; !ok && !strings.HasPrefix(k, "$") {
+			patch[k] = nil
+		}
+	}
+
+	return patch
+}
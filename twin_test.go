@@ -0,0 +1,227 @@
+package iothub
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// twinResponder returns an onPublish function that answers twin requests published to topics with the given prefix
+// with the given status and body.
+func twinResponder(prefix string, status int, body string) func(*fakeClient, string, []byte) {
+	return func(c *fakeClient, topic string, payload []byte) {
+		if !strings.HasPrefix(topic, prefix) {
+			return
+		}
+		rid, _ := ParseRequestID(topic)
+		c.deliver(fmt.Sprintf("$iothub/twin/res/%d/?$rid=%s", status, rid), []byte(body))
+	}
+}
+
+func TestGetTwin(t *testing.T) {
+	want := `{"desired":{"$version":1},"reported":{"$version":1}}`
+	client := &fakeClient{
+		onPublish: twinResponder("$iothub/twin/GET/", 200, want),
+	}
+
+	got, err := device.GetTwin(context.Background(), client)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, ok := client.subscriptions[device.TwinResponseTopic()]; !ok {
+		t.Errorf("not subscribed to %q", device.TwinResponseTopic())
+	}
+}
+
+func TestGetTwinErrorStatus(t *testing.T) {
+	client := &fakeClient{
+		onPublish: twinResponder("$iothub/twin/GET/", 429, ""),
+	}
+
+	if _, err := device.GetTwin(context.Background(), client); err == nil {
+		t.Error("expected error for status 429")
+	}
+}
+
+func TestGetTwinMismatchedRequestID(t *testing.T) {
+	client := &fakeClient{
+		onPublish: func(c *fakeClient, topic string, payload []byte) {
+			c.deliver("$iothub/twin/res/200/?$rid=not-the-right-one", []byte("{}"))
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := device.GetTwin(ctx, client); err == nil {
+		t.Error("expected error when no response matches the request ID")
+	}
+}
+
+func TestUpdateReportedProperties(t *testing.T) {
+	client := &fakeClient{
+		onPublish: twinResponder("$iothub/twin/PATCH/properties/reported/", 204, ""),
+	}
+
+	patch := []byte(`{"temp": 18.0}`)
+	if err := device.UpdateReportedProperties(context.Background(), client, patch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(client.published) != 1 {
+		t.Fatalf("got %d published messages, want 1", len(client.published))
+	}
+	if got := client.published[0].payload; string(got) != string(patch) {
+		t.Errorf("got payload %q, want %q", got, patch)
+	}
+}
+
+func TestUpdateReportedPropertiesErrorStatus(t *testing.T) {
+	client := &fakeClient{
+		onPublish: twinResponder("$iothub/twin/PATCH/properties/reported/", 400, ""),
+	}
+
+	if err := device.UpdateReportedProperties(context.Background(), client, []byte(`{"temp": 18.0}`)); err == nil {
+		t.Error("expected error for status 400")
+	}
+}
+
+func TestOnDesiredPropertiesChange(t *testing.T) {
+	var gotVersion int
+	var gotPatch []byte
+	client := &fakeClient{}
+	err := device.OnDesiredPropertiesChange(client, func(version int, patch []byte) {
+		gotVersion = version
+		gotPatch = patch
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `{"interval": 30, "$version": 7}`
+	client.deliver("$iothub/twin/PATCH/properties/desired/?$version=7", []byte(want))
+
+	if gotVersion != 7 {
+		t.Errorf("got version %d, want %d", gotVersion, 7)
+	}
+	if string(gotPatch) != want {
+		t.Errorf("got patch %q, want %q", gotPatch, want)
+	}
+}
+
+type testDesired struct {
+	Interval int    `json:"interval"`
+	Mode     string `json:"mode"`
+}
+
+func TestGetTwinTyped(t *testing.T) {
+	client := &fakeClient{
+		onPublish: twinResponder("$iothub/twin/GET/", 200,
+			`{"desired":{"interval":30,"mode":"eco","other":true,"$version":4},"reported":{"interval":10,"$version":2}}`),
+	}
+
+	got, err := GetTwinTyped[testDesired](context.Background(), &device, client)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (testDesired{Interval: 30, Mode: "eco"}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestGetTwinTypedMalformed(t *testing.T) {
+	client := &fakeClient{
+		onPublish: twinResponder("$iothub/twin/GET/", 200, `{"desired":{"interval":"thirty"}}`),
+	}
+
+	if _, err := GetTwinTyped[testDesired](context.Background(), &device, client); err == nil {
+		t.Error("expected error for a desired property of the wrong type")
+	}
+}
+
+func TestOnDesiredPropertiesChangeTyped(t *testing.T) {
+	var gotVersion int
+	var got testDesired
+	client := &fakeClient{}
+	err := OnDesiredPropertiesChangeTyped(&device, client, func(version int, patch testDesired) {
+		gotVersion, got = version, patch
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	client.deliver("$iothub/twin/PATCH/properties/desired/?$version=7", []byte(`{"interval": 60, "$version": 7}`))
+
+	if gotVersion != 7 {
+		t.Errorf("got version %d, want %d", gotVersion, 7)
+	}
+	if want := (testDesired{Interval: 60}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestTwinPatch(t *testing.T) {
+	cases := []struct {
+		name string
+		old  map[string]any
+		new  map[string]any
+		want map[string]any
+	}{
+		{
+			name: "unchanged",
+			old:  map[string]any{"a": 1.0, "b": map[string]any{"c": "x"}},
+			new:  map[string]any{"a": 1.0, "b": map[string]any{"c": "x"}},
+			want: map[string]any{},
+		},
+		{
+			name: "addition",
+			old:  map[string]any{"a": 1.0},
+			new:  map[string]any{"a": 1.0, "b": "x"},
+			want: map[string]any{"b": "x"},
+		},
+		{
+			name: "modification",
+			old:  map[string]any{"a": 1.0, "b": []any{"x"}},
+			new:  map[string]any{"a": 2.0, "b": []any{"x", "y"}},
+			want: map[string]any{"a": 2.0, "b": []any{"x", "y"}},
+		},
+		{
+			name: "deletion",
+			old:  map[string]any{"a": 1.0, "b": "x"},
+			new:  map[string]any{"a": 1.0},
+			want: map[string]any{"b": nil},
+		},
+		{
+			name: "nested",
+			old:  map[string]any{"fw": map[string]any{"version": "1.0", "channel": "beta", "size": 10.0}},
+			new:  map[string]any{"fw": map[string]any{"version": "1.1", "size": 10.0}},
+			want: map[string]any{"fw": map[string]any{"version": "1.1", "channel": nil}},
+		},
+		{
+			name: "object replaced by scalar",
+			old:  map[string]any{"a": map[string]any{"b": 1.0}},
+			new:  map[string]any{"a": "x"},
+			want: map[string]any{"a": "x"},
+		},
+		{
+			name: "metadata ignored",
+			old:  map[string]any{"a": 1.0, "$version": 3.0, "$metadata": map[string]any{}},
+			new:  map[string]any{"a": 1.0, "$version": 4.0},
+			want: map[string]any{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := TwinPatch(c.old, c.new); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}